@@ -0,0 +1,136 @@
+// Command mcc-replay feeds the inbound packets of a recorded session into a
+// new player on a server that runs a copy of a level.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+	"github.com/AndreasGoulas/go-mcc/mcc/memnet"
+)
+
+func main() {
+	levelsDir := flag.String("levels", "levels/", "directory of the levels")
+	levelName := flag.String("level", "main", "name of the level")
+	speed := flag.Float64("speed", 1, "playback speed, or 0 to replay without delays")
+	outDir := flag.String("out", "", "directory in which the level is saved after the replay")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] <recording>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := replay(flag.Arg(0), *levelsDir, *levelName, *speed, *outDir); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func replay(path, levelsDir, levelName string, speed float64, outDir string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := mcc.NewRecordReader(file)
+	if err != nil {
+		return err
+	}
+
+	level, err := mcc.NewCwStorage(levelsDir).Load(levelName)
+	if err != nil {
+		return err
+	}
+
+	storage := mcc.NewMemoryStorage()
+	storage.Save(level)
+
+	// The packets arrive as fast as the playback speed allows, so the limits
+	// must neither drop them nor kick the player during pauses.
+	unlimited := mcc.RateLimit{Rate: math.MaxInt32, Burst: math.MaxInt32}
+	config := &mcc.Config{
+		Name:          "Replay",
+		MOTD:          "Replay of " + path,
+		MaxPlayers:    8,
+		MainLevel:     levelName,
+		LoginTimeout:  math.MaxInt32,
+		IdleTimeout:   math.MaxInt32,
+		ChatLimit:     unlimited,
+		BlockLimit:    unlimited,
+		MovementLimit: unlimited,
+	}
+
+	server := mcc.NewServer(config, storage)
+	if server == nil {
+		return fmt.Errorf("replay: failed to create server")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	listener := memnet.NewListener()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(ctx, listener)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			log.Printf("replay: %s\n", err)
+		}
+	}()
+
+	conn, err := listener.Dial()
+	if err != nil {
+		return err
+	}
+	go io.Copy(ioutil.Discard, conn)
+
+	count := 0
+	start := time.Now()
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if entry.Direction != mcc.RecordInbound {
+			continue
+		}
+
+		if speed > 0 {
+			due := time.Duration(float64(entry.Time) / speed)
+			time.Sleep(due - time.Since(start))
+		}
+
+		if _, err := conn.Write(entry.Data); err != nil {
+			log.Printf("replay: connection closed after %d packets\n", count)
+			break
+		}
+
+		count++
+	}
+
+	log.Printf("replay: sent %d packets\n", count)
+	time.Sleep(mcc.UpdateInterval)
+	conn.Close()
+
+	if len(outDir) > 0 {
+		return mcc.NewCwStorage(outDir).Save(server.MainLevel)
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	passwordIterations = 100000
+	passwordSaltSize   = 16
+	passwordKeySize    = 32
+	passwordMinLength  = 6
+
+	// maxLoginAttempts failed logins lock the account for loginLockout.
+	maxLoginAttempts = 3
+	loginLockout     = 5 * time.Minute
+)
+
+func newAccount(password string) (*dbAccount, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	return &dbAccount{
+		Salt:       salt,
+		Hash:       pbkdf2.Key([]byte(password), salt, passwordIterations, passwordKeySize, sha256.New),
+		Iterations: passwordIterations,
+	}, nil
+}
+
+func (account *dbAccount) verify(password string) bool {
+	hash := pbkdf2.Key([]byte(password), account.Salt, account.Iterations, len(account.Hash), sha256.New)
+	return subtle.ConstantTimeCompare(hash, account.Hash) == 1
+}
+
+// setRank sets the rank of the player. The rank only applies once the player
+// has logged in, and is only kept on registration if the player had logged in
+// when it was given.
+func (player *player) setRank(rank *mcc.Rank) {
+	player.rank = rank
+	player.rankVerified = player.authenticated
+	if player.authenticated {
+		player.Rank = rank
+		player.SendPermissions()
+	}
+}
+
+func (plugin *plugin) isGuest(p *mcc.Player) bool {
+	player := plugin.findPlayer(p.Name())
+	return player != nil && !player.authenticated
+}
+
+func (plugin *plugin) promptLogin(p *mcc.Player) {
+	if _, ok := plugin.db.queryAccount(p.Name()); ok {
+		p.SendMessage("Please log in with /login <password>")
+	} else {
+		p.SendMessage("Please register with /register <password> <password>")
+	}
+}
+
+func (plugin *plugin) authenticate(player *player) {
+	player.authenticated = true
+	player.Rank = player.rank
+	player.SendPermissions()
+}
+
+// handleGuest prevents players that have not logged in from moving,
+// building, chatting and executing commands other than /login and /register.
+func (plugin *plugin) handleGuest(eventType int, event interface{}) {
+	switch e := event.(type) {
+	case *mcc.EventBlockPlace:
+		if plugin.isGuest(e.Player) {
+			e.Cancel = true
+			plugin.promptLogin(e.Player)
+		}
+
+	case *mcc.EventBlockBreak:
+		if plugin.isGuest(e.Player) {
+			e.Cancel = true
+			plugin.promptLogin(e.Player)
+		}
+
+	case *mcc.EventPlayerChat:
+		if plugin.isGuest(e.Player) {
+			e.Cancel = true
+			plugin.promptLogin(e.Player)
+		}
+
+	case *mcc.EventCommand:
+		p, ok := e.Sender.(*mcc.Player)
+		name := e.Command.Name
+		if ok && name != "login" && name != "register" && plugin.isGuest(p) {
+			e.Allow = false
+			plugin.promptLogin(p)
+		}
+
+	case *mcc.EventEntityMove:
+		player := plugin.findPlayer(e.Entity.Name())
+		if player != nil && player.Entity == e.Entity && !player.authenticated {
+			e.Cancel = true
+		}
+	}
+}
+
+func (plugin *plugin) handleLogin(sender mcc.CommandSender, command *mcc.Command, message string) {
+	if _, ok := sender.(*mcc.Player); !ok {
+		sender.SendMessage("You are not a player")
+		return
+	}
+
+	args := strings.Fields(message)
+	if len(args) != 1 {
+		command.PrintUsage(sender)
+		return
+	}
+
+	player := plugin.findPlayer(sender.Name())
+	if player.authenticated {
+		sender.SendMessage("You are already logged in")
+		return
+	}
+
+	account, ok := plugin.db.queryAccount(sender.Name())
+	if !ok {
+		sender.SendMessage("You are not registered, use /register <password> <password>")
+		return
+	}
+
+	// Failed logins are stored, so that reconnecting does not reset them.
+	failures := plugin.db.queryLoginFailures(sender.Name())
+	if time.Since(failures.LastAttempt) >= loginLockout {
+		failures.Attempts = 0
+	}
+
+	if failures.Attempts >= maxLoginAttempts {
+		player.Kick("Too many failed logins!")
+		return
+	}
+
+	if !account.verify(args[0]) {
+		failures.Attempts++
+		failures.LastAttempt = time.Now()
+		plugin.db.updateLoginFailures(sender.Name(), &failures)
+		if failures.Attempts >= maxLoginAttempts {
+			player.Kick("Too many failed logins!")
+		} else {
+			sender.SendMessage("Wrong password")
+		}
+		return
+	}
+
+	plugin.db.resetLoginFailures(sender.Name())
+	plugin.authenticate(player)
+	sender.SendMessage("Logged in")
+}
+
+func (plugin *plugin) handleRegister(sender mcc.CommandSender, command *mcc.Command, message string) {
+	if _, ok := sender.(*mcc.Player); !ok {
+		sender.SendMessage("You are not a player")
+		return
+	}
+
+	args := strings.Fields(message)
+	if len(args) != 2 {
+		command.PrintUsage(sender)
+		return
+	}
+
+	if _, ok := plugin.db.queryAccount(sender.Name()); ok {
+		sender.SendMessage("You are already registered")
+		return
+	}
+
+	if args[0] != args[1] {
+		sender.SendMessage("Passwords do not match")
+		return
+	}
+
+	if len(args[0]) < passwordMinLength {
+		sender.SendMessage("Password must be at least " +
+			strconv.Itoa(passwordMinLength) + " characters long")
+		return
+	}
+
+	account, err := newAccount(args[0])
+	if err != nil {
+		sender.SendMessage("Registration failed")
+		return
+	}
+
+	plugin.db.updateAccount(sender.Name(), account)
+
+	// Anyone can register a name that has no account yet, so a rank that
+	// was given to a guest with that name is only restored by an operator.
+	player := plugin.findPlayer(sender.Name())
+	if rank := plugin.findRank(plugin.defaultRank); player.rank != rank && !player.rankVerified {
+		log.Printf("handleRegister: rank of %s reset to the default rank\n", sender.Name())
+		sender.SendMessage("Your rank was reset, ask an operator to restore it")
+		player.rank = rank
+	}
+	player.rankVerified = true
+
+	if !player.authenticated {
+		plugin.authenticate(player)
+	}
+	sender.SendMessage("Registered")
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+	"github.com/AndreasGoulas/go-mcc/mcc/mcctest"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// newTestPlugin starts a harness that requires accounts, with Core using a
+// temporary database. The returned function stops the harness and removes
+// the database.
+func newTestPlugin(t *testing.T) (*mcctest.Harness, *plugin, func()) {
+	dir, err := ioutil.TempDir("", "core")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := newDb(filepath.Join(dir, "core.db"))
+	if db == nil {
+		os.RemoveAll(dir)
+		t.Fatal("failed to open the database")
+	}
+
+	harness := mcctest.NewHarness(t, nil)
+	plugin := newPlugin(db)
+	harness.Server.AddPlugin(plugin)
+	return harness, plugin, func() {
+		harness.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// connect logs in a player that does not support CPE, and discards the
+// packets of the login.
+func connect(harness *mcctest.Harness, name string) *mcctest.Player {
+	player := harness.Connect(name, mcctest.NoExtensions)
+	player.Skip()
+	return player
+}
+
+// disconnect closes player and waits until the server has removed it.
+func disconnect(harness *mcctest.Harness, player *mcctest.Player, name string) {
+	player.Close()
+	player.WaitUntil(func() bool {
+		return harness.Server.FindPlayer(name) == nil
+	})
+}
+
+func expectKick(t *testing.T, player *mcctest.Player, reason string) {
+	t.Helper()
+	player.WaitUntil(func() bool {
+		return player.KickReason() != ""
+	})
+
+	if player.KickReason() != reason {
+		t.Errorf("KickReason() = %q, want %q", player.KickReason(), reason)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	// RFC 7914, section 11.
+	hash, _ := hex.DecodeString("55ac046e56e3089fec1691c22544b605" +
+		"f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef31" +
+		"7c71b845b1e30bd509112041d3a19783")
+	account := dbAccount{Salt: []byte("salt"), Hash: hash, Iterations: 1}
+	if !account.verify("passwd") {
+		t.Error("verify(passwd) = false, want true")
+	}
+	if account.verify("passwd2") {
+		t.Error("verify(passwd2) = true, want false")
+	}
+
+	account = dbAccount{
+		Salt:       []byte("0123456789abcdef"),
+		Hash:       pbkdf2.Key([]byte("secret"), []byte("0123456789abcdef"), 1000, 32, sha256.New),
+		Iterations: 1000,
+	}
+	if !account.verify("secret") {
+		t.Error("verify(secret) = false with 1000 iterations, want true")
+	}
+
+	created, err := newAccount("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Iterations != passwordIterations || !created.verify("secret") || created.verify("Secret") {
+		t.Error("newAccount(secret) does not verify with its own iterations")
+	}
+}
+
+func TestStoredIterations(t *testing.T) {
+	harness, plugin, cleanup := newTestPlugin(t)
+	defer cleanup()
+
+	salt := []byte("0123456789abcdef")
+	plugin.db.updateAccount("alice", &dbAccount{
+		Salt:       salt,
+		Hash:       pbkdf2.Key([]byte("secret"), salt, 1000, 32, sha256.New),
+		Iterations: 1000,
+	})
+
+	account, ok := plugin.db.queryAccount("alice")
+	if !ok || account.Iterations != 1000 || !bytes.Equal(account.Salt, salt) {
+		t.Fatalf("queryAccount(alice) = %+v, %v", account, ok)
+	}
+
+	alice := connect(harness, "alice")
+	alice.SendMessage("/login secret")
+	alice.ExpectMessage("Logged in")
+}
+
+func TestLoginLockout(t *testing.T) {
+	harness, plugin, cleanup := newTestPlugin(t)
+	defer cleanup()
+
+	alice := connect(harness, "alice")
+	alice.SendMessage("/register secret secret")
+	alice.ExpectMessage("Registered")
+	disconnect(harness, alice, "alice")
+
+	// Reconnecting does not reset the failed logins.
+	for i := 1; i < maxLoginAttempts; i++ {
+		alice = connect(harness, "alice")
+		alice.SendMessage("/login wrong")
+		alice.ExpectMessage("Wrong password")
+		disconnect(harness, alice, "alice")
+	}
+
+	alice = connect(harness, "alice")
+	alice.SendMessage("/login wrong")
+	expectKick(t, alice, "Too many failed logins!")
+	disconnect(harness, alice, "alice")
+
+	// The name stays locked even with the right password.
+	alice = connect(harness, "alice")
+	alice.SendMessage("/login secret")
+	expectKick(t, alice, "Too many failed logins!")
+	disconnect(harness, alice, "alice")
+
+	plugin.db.updateLoginFailures("alice", &dbLoginFailures{
+		Attempts:    maxLoginAttempts,
+		LastAttempt: time.Now().Add(-loginLockout),
+	})
+
+	alice = connect(harness, "alice")
+	alice.SendMessage("/login secret")
+	alice.ExpectMessage("Logged in")
+	if failures := plugin.db.queryLoginFailures("alice"); failures.Attempts != 0 {
+		t.Errorf("Attempts = %d after login, want 0", failures.Attempts)
+	}
+}
+
+func TestGuest(t *testing.T) {
+	harness, plugin, cleanup := newTestPlugin(t)
+	defer cleanup()
+
+	connect(harness, "alice")
+	p := harness.Server.FindPlayer("alice")
+	level := p.Level()
+	say := &mcc.Command{Name: "say"}
+	login := &mcc.Command{Name: "login"}
+
+	check := func(guest bool) {
+		t.Helper()
+		blockPlace := mcc.EventBlockPlace{Player: p, Level: level}
+		harness.Server.FireEvent(mcc.EventTypeBlockPlace, &blockPlace)
+		if blockPlace.Cancel != guest {
+			t.Errorf("EventBlockPlace.Cancel = %v, want %v", blockPlace.Cancel, guest)
+		}
+
+		blockBreak := mcc.EventBlockBreak{Player: p, Level: level}
+		harness.Server.FireEvent(mcc.EventTypeBlockBreak, &blockBreak)
+		if blockBreak.Cancel != guest {
+			t.Errorf("EventBlockBreak.Cancel = %v, want %v", blockBreak.Cancel, guest)
+		}
+
+		chat := mcc.EventPlayerChat{Player: p, Message: "hello"}
+		harness.Server.FireEvent(mcc.EventTypePlayerChat, &chat)
+		if chat.Cancel != guest {
+			t.Errorf("EventPlayerChat.Cancel = %v, want %v", chat.Cancel, guest)
+		}
+
+		command := mcc.EventCommand{Sender: p, Command: say, Allow: true}
+		harness.Server.FireEvent(mcc.EventTypeCommand, &command)
+		if command.Allow == guest {
+			t.Errorf("EventCommand.Allow = %v, want %v", command.Allow, !guest)
+		}
+
+		command = mcc.EventCommand{Sender: p, Command: login, Allow: true}
+		harness.Server.FireEvent(mcc.EventTypeCommand, &command)
+		if !command.Allow {
+			t.Error("EventCommand.Allow = false for /login, want true")
+		}
+
+		move := mcc.EventEntityMove{Entity: p.Entity, From: p.Location(), To: p.Location()}
+		harness.Server.FireEvent(mcc.EventTypeEntityMove, &move)
+		if move.Cancel != guest {
+			t.Errorf("EventEntityMove.Cancel = %v, want %v", move.Cancel, guest)
+		}
+	}
+
+	check(true)
+	plugin.authenticate(plugin.findPlayer("alice"))
+	check(false)
+}
+
+func TestRegisterRank(t *testing.T) {
+	harness, plugin, cleanup := newTestPlugin(t)
+	defer cleanup()
+
+	op := plugin.findRank("op")
+	plugin.db.updatePlayer("bob", &dbPlayer{
+		Rank:         sql.NullString{String: "op", Valid: true},
+		RankVerified: true,
+		Nickname:     "bob",
+	})
+
+	// A rank given to a guest is reset when the name is registered.
+	alice := connect(harness, "alice")
+	plugin.findPlayer("alice").setRank(op)
+	alice.SendMessage("/register secret secret")
+	alice.ExpectMessage("Your rank was reset")
+	alice.ExpectMessage("Registered")
+	if rank := plugin.findPlayer("alice").rank; rank != nil {
+		t.Errorf("rank of alice = %v, want the default rank", rank)
+	}
+
+	// A rank given before accounts existed is kept.
+	bob := connect(harness, "bob")
+	bob.SendMessage("/register secret secret")
+	bob.ExpectMessage("Registered")
+	if rank := plugin.findPlayer("bob").rank; rank != op {
+		t.Errorf("rank of bob = %v, want op", rank)
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -73,18 +76,70 @@ INSERT INTO config(cfg_key, cfg_value)
 VALUES("default_rank", "");
 `
 
+// dbUpgrade is executed every time the database is opened. It creates the
+// tables that were added after the initial schema.
+const dbUpgrade = `
+CREATE TABLE IF NOT EXISTS spawns(
+	player TEXT NOT NULL,
+	level TEXT NOT NULL,
+	x REAL NOT NULL,
+	y REAL NOT NULL,
+	z REAL NOT NULL,
+	yaw REAL NOT NULL,
+	pitch REAL NOT NULL,
+	PRIMARY KEY (player, level)
+);
+
+CREATE TABLE IF NOT EXISTS accounts(
+	name TEXT PRIMARY KEY,
+	salt BLOB NOT NULL,
+	hash BLOB NOT NULL,
+	iterations INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS login_failures(
+	name TEXT PRIMARY KEY,
+	attempts INTEGER NOT NULL,
+	last_attempt DATETIME NOT NULL
+);
+`
+
+// dbVersion is the user_version of a database on which all the migrations
+// of migrate have run.
+const dbVersion = 2
+
 type dbLevel struct {
 	MOTD    string `db:"motd"`
 	Physics bool   `db:"physics"`
 }
 
 type dbPlayer struct {
-	Rank       sql.NullString `db:"rank"`
-	FirstLogin time.Time      `db:"first_login"`
-	LastLogin  time.Time      `db:"last_login"`
-	Nickname   string         `db:"nickname"`
-	IgnoreList string         `db:"ignore_list"`
-	Mute       bool           `db:"mute"`
+	Rank         sql.NullString `db:"rank"`
+	RankVerified bool           `db:"rank_verified"`
+	FirstLogin   time.Time      `db:"first_login"`
+	LastLogin    time.Time      `db:"last_login"`
+	Nickname     string         `db:"nickname"`
+	IgnoreList   string         `db:"ignore_list"`
+	Mute         bool           `db:"mute"`
+}
+
+type dbSpawn struct {
+	X     float64 `db:"x"`
+	Y     float64 `db:"y"`
+	Z     float64 `db:"z"`
+	Yaw   float64 `db:"yaw"`
+	Pitch float64 `db:"pitch"`
+}
+
+type dbAccount struct {
+	Salt       []byte `db:"salt"`
+	Hash       []byte `db:"hash"`
+	Iterations int    `db:"iterations"`
+}
+
+type dbLoginFailures struct {
+	Attempts    int       `db:"attempts"`
+	LastAttempt time.Time `db:"last_attempt"`
 }
 
 type dbRank struct {
@@ -122,8 +177,67 @@ func newDb(path string) *db {
 	if version == 0 {
 		pdb.MustExec(dbSchema)
 	}
+	pdb.MustExec(dbUpgrade)
 
-	return &db{DB: pdb}
+	db := &db{DB: pdb}
+	db.migrate()
+	return db
+}
+
+// migrate runs the upgrades that cannot be written in SQL. Each of them runs
+// once, and user_version records the ones that have run.
+func (db *db) migrate() {
+	var version int
+	db.Get(&version, "PRAGMA user_version")
+	if version < 1 {
+		db.normalizeBannedIPs()
+	}
+	if version < 2 {
+		db.verifyRanks()
+	}
+
+	if version < dbVersion {
+		db.MustExec(fmt.Sprintf("PRAGMA user_version = %d", dbVersion))
+	}
+}
+
+// normalizeBannedIPs converts the banned addresses to the canonical form of
+// Player.RemoteAddr, so that bans stored by older versions keep matching.
+func (db *db) normalizeBannedIPs() {
+	var ips []string
+	db.Select(&ips, "SELECT ip FROM banned_ips")
+
+	tx := db.MustBegin()
+	for _, ip := range ips {
+		addr := ip
+		if i := strings.LastIndexByte(addr, '%'); i >= 0 {
+			addr = addr[:i]
+		}
+
+		parsed := net.ParseIP(addr)
+		if parsed == nil || parsed.String() == ip {
+			continue
+		}
+
+		tx.MustExec("UPDATE OR REPLACE banned_ips SET ip = ? WHERE ip = ?", parsed.String(), ip)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("normalizeBannedIPs: %s\n", err)
+	}
+}
+
+// verifyRanks adds the rank_verified column to the players table. The ranks
+// that were given before accounts existed are verified, so that their players
+// keep them when they register.
+func (db *db) verifyRanks() {
+	tx := db.MustBegin()
+	tx.MustExec(`
+ALTER TABLE players ADD COLUMN rank_verified INTEGER NOT NULL DEFAULT 0`)
+	tx.MustExec("UPDATE players SET rank_verified = 1")
+	if err := tx.Commit(); err != nil {
+		log.Printf("verifyRanks: %s\n", err)
+	}
 }
 
 func (db *db) ban(name, reason, banned_by string) {
@@ -160,16 +274,16 @@ SELECT reason FROM banned_names WHERE name = ?`, addr, name)
 
 func (db *db) queryPlayer(name string) (player dbPlayer, ok bool) {
 	ok = db.Get(&player, `
-SELECT rank, first_login, last_login, nickname,
+SELECT rank, rank_verified, first_login, last_login, nickname,
 ignore_list, mute FROM players WHERE name = ?`, name) != sql.ErrNoRows
 	return
 }
 
 func (db *db) updatePlayer(name string, player *dbPlayer) {
 	db.MustExec(`
-REPLACE INTO players(name, rank, first_login, last_login,
-nickname, ignore_list, mute) VALUES(?, ?, ?, ?, ?, ?, ?)`,
-		name, player.Rank, player.FirstLogin, player.LastLogin,
+REPLACE INTO players(name, rank, rank_verified, first_login, last_login,
+nickname, ignore_list, mute) VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+		name, player.Rank, player.RankVerified, player.FirstLogin, player.LastLogin,
 		player.Nickname, player.IgnoreList, player.Mute)
 }
 
@@ -184,6 +298,53 @@ func (db *db) updateLevel(name string, level *dbLevel) {
 		name, level.MOTD, level.Physics)
 }
 
+func (db *db) querySpawn(player, level string) (spawn dbSpawn, ok bool) {
+	ok = db.Get(&spawn, `
+SELECT x, y, z, yaw, pitch FROM spawns
+WHERE player = ? AND level = ?`, player, level) != sql.ErrNoRows
+	return
+}
+
+func (db *db) updateSpawn(player, level string, spawn *dbSpawn) {
+	db.MustExec(`
+REPLACE INTO spawns(player, level, x, y, z, yaw, pitch)
+VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		player, level, spawn.X, spawn.Y, spawn.Z, spawn.Yaw, spawn.Pitch)
+}
+
+func (db *db) deleteSpawn(player, level string) {
+	db.MustExec("DELETE FROM spawns WHERE player = ? AND level = ?",
+		player, level)
+}
+
+func (db *db) queryAccount(name string) (account dbAccount, ok bool) {
+	ok = db.Get(&account, `
+SELECT salt, hash, iterations FROM accounts WHERE name = ?`, name) != sql.ErrNoRows
+	return
+}
+
+func (db *db) queryLoginFailures(name string) (failures dbLoginFailures) {
+	db.Get(&failures, `
+SELECT attempts, last_attempt FROM login_failures WHERE name = ?`, name)
+	return
+}
+
+func (db *db) updateLoginFailures(name string, failures *dbLoginFailures) {
+	db.MustExec(`
+REPLACE INTO login_failures(name, attempts, last_attempt) VALUES(?, ?, ?)`,
+		name, failures.Attempts, failures.LastAttempt)
+}
+
+func (db *db) resetLoginFailures(name string) {
+	db.MustExec("DELETE FROM login_failures WHERE name = ?", name)
+}
+
+func (db *db) updateAccount(name string, account *dbAccount) {
+	db.MustExec(`
+REPLACE INTO accounts(name, salt, hash, iterations) VALUES(?, ?, ?, ?)`,
+		name, account.Salt, account.Hash, account.Iterations)
+}
+
 func (db *db) queryRanks() (ranks []dbRank) {
 	db.Select(&ranks, "SELECT name, tag, permissions FROM ranks")
 	return
@@ -254,13 +254,33 @@ func (plugin *plugin) handleSetSpawn(sender mcc.CommandSender, command *mcc.Comm
 	}
 
 	args := strings.Fields(message)
+	if len(args) > 0 && args[0] == "me" {
+		switch {
+		case len(args) == 1:
+			player.SetSpawn()
+			plugin.saveSpawn(player, player.Level())
+			sender.SendMessage("Personal spawn location set to your current location")
+
+		case len(args) == 2 && args[1] == "reset":
+			player.ResetSpawnLocation()
+			plugin.saveSpawn(player, player.Level())
+			sender.SendMessage("Personal spawn location reset")
+
+		default:
+			command.PrintUsage(sender)
+		}
+
+		return
+	}
+
 	switch len(args) {
 	case 0:
 		level := player.Level()
 		level.Spawn = player.Location()
 		level.Dirty = true
 
-		player.SetSpawn()
+		player.ResetSpawnLocation()
+		plugin.saveSpawn(player, level)
 		sender.SendMessage("Spawn location set to your current location")
 
 	case 1:
@@ -277,6 +297,7 @@ func (plugin *plugin) handleSetSpawn(sender mcc.CommandSender, command *mcc.Comm
 
 		target.Teleport(player.Location())
 		target.SetSpawn()
+		plugin.saveSpawn(target, target.Level())
 		sender.SendMessage("Spawn location of " + player.Name() + " set to your current location")
 
 	default:
@@ -296,7 +317,22 @@ func (plugin *plugin) handleSpawn(sender mcc.CommandSender, command *mcc.Command
 		return
 	}
 
-	player.Teleport(player.Level().Spawn)
+	player.Teleport(player.SpawnLocation())
+}
+
+func (plugin *plugin) handleEntityLevelChange(eventType int, event interface{}) {
+	e := event.(*mcc.EventEntityLevelChange)
+	player := plugin.findPlayer(e.Entity.Name())
+	if player == nil {
+		return
+	}
+
+	if e.From != nil {
+		plugin.saveSpawn(player.Player, e.From)
+	}
+	if e.To != nil {
+		plugin.loadSpawn(player.Player, e.To)
+	}
 }
 
 func (plugin *plugin) handleUnload(sender mcc.CommandSender, command *mcc.Command, message string) {
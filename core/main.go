@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -61,6 +62,13 @@ type player struct {
 	lastSender   string
 	lastLevel    *mcc.Level
 	lastLocation mcc.Location
+
+	// rank is the rank of the account, which is applied once the player
+	// has logged in. rankVerified reports whether it was given to a player
+	// whose name was verified, either by logging in or by the heartbeat.
+	rank          *mcc.Rank
+	rankVerified  bool
+	authenticated bool
 }
 
 func (player *player) isIgnored(name string) bool {
@@ -76,15 +84,20 @@ func (player *player) isIgnored(name string) bool {
 type plugin struct {
 	db *db
 
-	defaultRank string
-	ranks       map[string]*mcc.Rank
-	ranksLock   sync.RWMutex
+	defaultRank  string
+	authRequired bool
+	ranks        map[string]*mcc.Rank
+	ranksLock    sync.RWMutex
 
 	levels     map[string]*level
 	levelsLock sync.RWMutex
 
 	players     map[string]*player
 	playersLock sync.RWMutex
+
+	floodBanThreshold int
+	floodCounts       map[string]int
+	floodCountsLock   sync.Mutex
 }
 
 func Initialize() mcc.Plugin {
@@ -93,10 +106,15 @@ func Initialize() mcc.Plugin {
 		return nil
 	}
 
+	return newPlugin(db)
+}
+
+func newPlugin(db *db) *plugin {
 	return &plugin{
-		db:      db,
-		levels:  make(map[string]*level),
-		players: make(map[string]*player),
+		db:          db,
+		levels:      make(map[string]*level),
+		players:     make(map[string]*player),
+		floodCounts: make(map[string]int),
 	}
 }
 
@@ -106,6 +124,8 @@ func (plugin *plugin) Name() string {
 
 func (plugin *plugin) Enable(server *mcc.Server) {
 	plugin.loadRanks()
+	plugin.floodBanThreshold, _ = strconv.Atoi(plugin.db.queryConfig("flood_ban_threshold"))
+	plugin.authRequired = !server.Config.Verify
 
 	server.AddCommand(&mcc.Command{
 		Name:        "back",
@@ -198,6 +218,13 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleLoad,
 	})
 
+	server.AddCommand(&mcc.Command{
+		Name:        "login",
+		Description: "Log in to your account.",
+		Usage:       "/login <password>",
+		Handler:     plugin.handleLogin,
+	})
+
 	server.AddCommand(&mcc.Command{
 		Name:        "main",
 		Description: "Set the main level.",
@@ -267,6 +294,13 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleRank,
 	})
 
+	server.AddCommand(&mcc.Command{
+		Name:        "register",
+		Description: "Create an account with a password.",
+		Usage:       "/register <password> <password>",
+		Handler:     plugin.handleRegister,
+	})
+
 	server.AddCommand(&mcc.Command{
 		Name:        "save",
 		Description: "Save a level.",
@@ -293,7 +327,7 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 	server.AddCommand(&mcc.Command{
 		Name:        "setspawn",
 		Description: "Set the spawn location of the level to your location.",
-		Usage:       "/setspawn [player]",
+		Usage:       "/setspawn [player | me [reset]]",
 		Permissions: PermLevel,
 		Handler:     plugin.handleSetSpawn,
 	})
@@ -362,19 +396,33 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 
 	server.AddHandler(mcc.EventTypePlayerLogin, plugin.handlePlayerLogin)
 	server.AddHandler(mcc.EventTypePlayerChat, plugin.handlePlayerChat)
+	server.AddHandler(mcc.EventTypeRateLimit, plugin.handleRateLimit)
+
+	server.AddHandler(mcc.EventTypeBlockPlace, plugin.handleGuest)
+	server.AddHandler(mcc.EventTypeBlockBreak, plugin.handleGuest)
+	server.AddHandler(mcc.EventTypePlayerChat, plugin.handleGuest)
+	server.AddHandler(mcc.EventTypeCommand, plugin.handleGuest)
+	server.AddHandler(mcc.EventTypeEntityMove, plugin.handleGuest)
 
 	server.AddHandler(mcc.EventTypePlayerJoin, func(eventType int, event interface{}) {
 		e := event.(*mcc.EventPlayerJoin)
-		plugin.addPlayer(e.Player)
+		if player := plugin.addPlayer(e.Player); !player.authenticated {
+			plugin.promptLogin(e.Player)
+		}
 	})
 
 	server.AddHandler(mcc.EventTypePlayerQuit, func(eventType int, event interface{}) {
 		e := event.(*mcc.EventPlayerQuit)
 		player := plugin.findPlayer(e.Player.Name())
 		plugin.savePlayer(player)
+		if level := e.Player.Level(); level != nil {
+			plugin.saveSpawn(e.Player, level)
+		}
 		plugin.removePlayer(e.Player)
 	})
 
+	server.AddHandler(mcc.EventTypeEntityLevelChange, plugin.handleEntityLevelChange)
+
 	server.AddHandler(mcc.EventTypeLevelLoad, func(eventType int, event interface{}) {
 		e := event.(*mcc.EventLevelLoad)
 		plugin.addLevel(e.Level)
@@ -471,9 +519,10 @@ func (plugin *plugin) addPlayer(p *mcc.Player) *player {
 	}
 
 	player := &player{
-		Player:     p,
-		firstLogin: db.FirstLogin,
-		lastLogin:  time.Now(),
+		Player:        p,
+		firstLogin:    db.FirstLogin,
+		lastLogin:     time.Now(),
+		authenticated: !plugin.authRequired,
 	}
 
 	player.Nickname = db.Nickname
@@ -481,7 +530,11 @@ func (plugin *plugin) addPlayer(p *mcc.Player) *player {
 		player.ignoreList = strings.Split(db.IgnoreList, ",")
 	}
 	if db.Rank.Valid {
-		player.Rank = plugin.findRank(db.Rank.String)
+		player.rank = plugin.findRank(db.Rank.String)
+	}
+	player.rankVerified = db.RankVerified
+	if player.authenticated {
+		player.Rank = player.rank
 	}
 
 	plugin.playersLock.Lock()
@@ -504,17 +557,43 @@ func (plugin *plugin) findPlayer(name string) *player {
 
 func (plugin *plugin) savePlayer(player *player) {
 	var rank sql.NullString
-	if player.Rank != nil {
-		rank = sql.NullString{String: player.Rank.Name, Valid: true}
+	if player.rank != nil {
+		rank = sql.NullString{String: player.rank.Name, Valid: true}
 	}
 
 	plugin.db.updatePlayer(player.Name(), &dbPlayer{
-		Rank:       rank,
-		FirstLogin: player.firstLogin,
-		LastLogin:  player.lastLogin,
-		Nickname:   player.Nickname,
-		IgnoreList: strings.Join(player.ignoreList, ","),
-		Mute:       player.mute,
+		Rank:         rank,
+		RankVerified: player.rankVerified,
+		FirstLogin:   player.firstLogin,
+		LastLogin:    player.lastLogin,
+		Nickname:     player.Nickname,
+		IgnoreList:   strings.Join(player.ignoreList, ","),
+		Mute:         player.mute,
+	})
+}
+
+// loadSpawn applies the personal spawn location of p in level that is
+// stored in the database.
+func (plugin *plugin) loadSpawn(p *mcc.Player, level *mcc.Level) {
+	if spawn, ok := plugin.db.querySpawn(p.Name(), level.Name); ok {
+		p.SetSpawnLocation(mcc.Location{
+			X: spawn.X, Y: spawn.Y, Z: spawn.Z,
+			Yaw: spawn.Yaw, Pitch: spawn.Pitch,
+		})
+	}
+}
+
+// saveSpawn stores the personal spawn location of p in level.
+func (plugin *plugin) saveSpawn(p *mcc.Player, level *mcc.Level) {
+	location, ok := p.PersonalSpawn(level)
+	if !ok {
+		plugin.db.deleteSpawn(p.Name(), level.Name)
+		return
+	}
+
+	plugin.db.updateSpawn(p.Name(), level.Name, &dbSpawn{
+		location.X, location.Y, location.Z,
+		location.Yaw, location.Pitch,
 	})
 }
 
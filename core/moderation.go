@@ -44,19 +44,22 @@ func (plugin *plugin) handleBanIp(sender mcc.CommandSender, command *mcc.Command
 		reason = args[1]
 	}
 
-	if net.ParseIP(args[0]) == nil {
+	ip := net.ParseIP(args[0])
+	if ip == nil {
 		sender.SendMessage(args[0] + " is not a valid IP address")
 		return
 	}
 
-	plugin.db.banIP(args[0], reason, sender.Name())
+	// Addresses are stored in the same canonical form as Player.RemoteAddr.
+	addr := ip.String()
+	plugin.db.banIP(addr, reason, sender.Name())
 	sender.Server().ForEachPlayer(func(player *mcc.Player) {
-		if player.RemoteAddr() == args[0] {
+		if player.RemoteAddr() == addr {
 			player.Kick(reason)
 		}
 	})
 
-	sender.SendMessage("IP " + args[0] + " banned")
+	sender.SendMessage("IP " + addr + " banned")
 }
 
 func (plugin *plugin) handleKick(sender mcc.CommandSender, command *mcc.Command, message string) {
@@ -101,8 +104,7 @@ func (plugin *plugin) handleRank(sender mcc.CommandSender, command *mcc.Command,
 	if player := plugin.findPlayer(args[0]); player == nil {
 		sender.SendMessage("Player " + args[0] + " not found")
 	} else {
-		player.Rank = rank
-		player.SendPermissions()
+		player.setRank(rank)
 		if rank == nil {
 			sender.SendMessage("Rank of " + args[0] + " reset")
 		} else {
@@ -132,9 +134,35 @@ func (plugin *plugin) handleUnbanIp(sender mcc.CommandSender, command *mcc.Comma
 		return
 	}
 
-	if plugin.db.unbanIP(args[0]) {
-		sender.SendMessage("IP " + args[0] + " unbanned")
+	addr := args[0]
+	if ip := net.ParseIP(addr); ip != nil {
+		addr = ip.String()
+	}
+
+	if plugin.db.unbanIP(addr) {
+		sender.SendMessage("IP " + addr + " unbanned")
 	} else {
-		sender.SendMessage("IP " + args[0] + " is not banned")
+		sender.SendMessage("IP " + addr + " is not banned")
+	}
+}
+
+// handleRateLimit bans the IP address of clients that exceed the rate limits
+// more than flood_ban_threshold times.
+func (plugin *plugin) handleRateLimit(eventType int, event interface{}) {
+	e := event.(*mcc.EventRateLimit)
+	if plugin.floodBanThreshold <= 0 {
+		return
+	}
+
+	plugin.floodCountsLock.Lock()
+	plugin.floodCounts[e.Addr]++
+	count := plugin.floodCounts[e.Addr]
+	if count >= plugin.floodBanThreshold {
+		delete(plugin.floodCounts, e.Addr)
+	}
+	plugin.floodCountsLock.Unlock()
+
+	if count >= plugin.floodBanThreshold {
+		plugin.db.banIP(e.Addr, "Flooding", "Console")
 	}
 }
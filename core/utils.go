@@ -66,13 +66,13 @@ func envOption(option string, arg string, config *mcc.EnvConfig) int32 {
 
 	case "sideblock":
 		if v, err := strconv.ParseUint(arg, 10, 64); err == nil && v <= mcc.BlockMax {
-			config.SideBlock = byte(v)
+			config.SideBlock = uint16(v)
 			return mcc.EnvPropSideBlock
 		}
 
 	case "edgeblock":
 		if v, err := strconv.ParseUint(arg, 10, 64); err == nil && v <= mcc.BlockMax {
-			config.EdgeBlock = byte(v)
+			config.EdgeBlock = uint16(v)
 			return mcc.EnvPropEdgeBlock
 		}
 
@@ -5,4 +5,5 @@ go 1.12
 require (
 	github.com/jmoiron/sqlx v1.3.1
 	github.com/mattn/go-sqlite3 v1.14.6
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 )
@@ -2,13 +2,16 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"plugin"
-	"sync"
+	"time"
 
 	"github.com/AndreasGoulas/go-mcc/mcc"
 )
@@ -29,16 +32,16 @@ const (
 )
 
 type console struct {
-	server    *mcc.Server
-	waitGroup *sync.WaitGroup
-	signal    chan os.Signal
+	server *mcc.Server
+	cancel context.CancelFunc
+	signal chan os.Signal
 }
 
-func newConsole(server *mcc.Server, waitGroup *sync.WaitGroup) *console {
+func newConsole(server *mcc.Server, cancel context.CancelFunc) *console {
 	console := &console{
 		server,
-		waitGroup,
-		make(chan os.Signal),
+		cancel,
+		make(chan os.Signal, 1),
 	}
 
 	server.AddCommand(&mcc.Command{
@@ -49,6 +52,14 @@ func newConsole(server *mcc.Server, waitGroup *sync.WaitGroup) *console {
 		Handler:     console.handleStop,
 	})
 
+	server.AddCommand(&mcc.Command{
+		Name:        "heartbeat",
+		Description: "Show the status of the heartbeat services.",
+		Usage:       "/heartbeat",
+		Permissions: PermOperator,
+		Handler:     console.handleHeartbeat,
+	})
+
 	signal.Notify(console.signal, os.Interrupt)
 	go func() {
 		signal := <-console.signal
@@ -68,9 +79,7 @@ func (console *console) run() {
 }
 
 func (console *console) stop() {
-	console.server.Stop()
-	console.waitGroup.Wait()
-	os.Exit(0)
+	console.cancel()
 }
 
 // Server implements mcc.CommandSender.
@@ -97,6 +106,28 @@ func (console *console) handleStop(sender mcc.CommandSender, command *mcc.Comman
 	console.stop()
 }
 
+func (console *console) handleHeartbeat(sender mcc.CommandSender, command *mcc.Command, message string) {
+	count := 0
+	console.server.ForEachHeartbeat(func(heartbeat *mcc.Heartbeat) {
+		count++
+		playURL, lastSent, err := heartbeat.Status()
+		switch {
+		case lastSent.IsZero():
+			sender.SendMessage(heartbeat.URL() + ": not sent yet")
+		case err != nil:
+			sender.SendMessage(fmt.Sprintf("%s: %s (%s ago)", heartbeat.URL(), err,
+				time.Since(lastSent).Round(time.Second)))
+		default:
+			sender.SendMessage(fmt.Sprintf("%s: %s (%s ago)", heartbeat.URL(), playURL,
+				time.Since(lastSent).Round(time.Second)))
+		}
+	})
+
+	if count == 0 {
+		sender.SendMessage("No heartbeat services configured")
+	}
+}
+
 func readConfig(path string) *mcc.Config {
 	file, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -156,6 +187,29 @@ func loadPlugins(path string, server *mcc.Server) {
 	}
 }
 
+func loadModels(path string, server *mcc.Server) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		model, err := mcc.LoadModel(path + file.Name())
+		if err != nil {
+			log.Printf("loadModels: %s\n", err)
+			continue
+		}
+
+		if err := server.AddModel(model); err != nil {
+			log.Printf("loadModels: %s\n", err)
+		}
+	}
+}
+
 func main() {
 	config := readConfig("server.json")
 	cwstorage := mcc.NewCwStorage("levels/")
@@ -164,14 +218,22 @@ func main() {
 		return
 	}
 
+	loadModels("models/", server)
 	loadPlugins("plugins/", server)
 
-	var wg sync.WaitGroup
-	if err := server.Start(&wg); err != nil {
-		log.Println(err)
-		return
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	console := newConsole(server, cancel)
+	go console.run()
+
+	listeners, err := server.Listen()
+	if err != nil {
+		server.DisablePlugins()
+		log.Fatal(err)
 	}
 
-	console := newConsole(server, &wg)
-	console.run()
+	if err := server.Serve(ctx, listeners...); err != nil {
+		log.Printf("main: %s\n", err)
+	}
 }
@@ -75,7 +75,9 @@ const (
 	BlockMaxCPE   = BlockStoneBrick
 	BlockCountCPE = BlockMaxCPE + 1
 
-	BlockMax   = 255
+	BlockMaxDefinitions = 255
+
+	BlockMax   = 767
 	BlockCount = BlockMax + 1
 )
 
@@ -93,7 +95,7 @@ var BlockName = [BlockCountCPE]string{
 }
 
 // FallbackBlock converts a CPE block to a similar vanilla-compatible one.
-func FallbackBlock(block byte) byte {
+func FallbackBlock(block uint16) uint16 {
 	switch block {
 	case BlockCobblestoneSlab:
 		return BlockSlab
@@ -176,7 +178,7 @@ const (
 // BlockDefinition describes a custom block.
 type BlockDefinition struct {
 	Name     string
-	Fallback byte
+	Fallback uint16
 
 	Speed       float64
 	CollideMode byte
@@ -0,0 +1,848 @@
+// Package client implements a client for the Minecraft Classic protocol.
+// It can be used to test plugins, run bots and mirror the state of a server.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+	"github.com/AndreasGoulas/go-mcc/mcc/internal/proto"
+)
+
+const SelfID = 0xff
+
+// Config holds the options of a client.
+type Config struct {
+	Name    string
+	Key     string
+	AppName string
+
+	// Extensions is the list of CPE extensions that the client advertises.
+	// If it is nil, mcc.Extensions is used. CPE is not requested if it is
+	// empty.
+	Extensions []mcc.ExtEntry
+}
+
+// Entity represents an entity that was spawned by the server.
+type Entity struct {
+	ID       byte
+	Name     string
+	SkinName string
+	Model    string
+	Location mcc.Location
+}
+
+// Client represents a connection to a server.
+type Client struct {
+	config Config
+
+	conn      net.Conn
+	reader    *bufio.Reader
+	writeLock sync.Mutex
+
+	cpe           [mcc.CpeCount]bool
+	remExtensions int
+	extensions    []mcc.ExtEntry
+
+	serverName string
+	motd       string
+	loggedIn   bool
+
+	closed     bool
+	kickReason string
+	stateLock  sync.RWMutex
+
+	level        *mcc.Level
+	levelSize    int
+	levelData    [2]bytes.Buffer
+	blockDefs    []*mcc.BlockDefinition
+	levelLock    sync.RWMutex
+	heldBlock    uint16
+	location     mcc.Location
+	locationLock sync.RWMutex
+
+	entities     map[byte]*Entity
+	entitiesLock sync.RWMutex
+
+	handlers     map[int][]mcc.EventHandler
+	handlersLock sync.RWMutex
+}
+
+// NewClient returns a new client that communicates over conn.
+func NewClient(conn net.Conn, config Config) *Client {
+	if config.AppName == "" {
+		config.AppName = mcc.ServerSoftware
+	}
+
+	extensions := config.Extensions
+	if extensions == nil {
+		extensions = mcc.Extensions[:]
+	}
+
+	return &Client{
+		config:     config,
+		conn:       conn,
+		reader:     bufio.NewReader(conn),
+		extensions: extensions,
+		entities:   make(map[byte]*Entity),
+		handlers:   make(map[int][]mcc.EventHandler),
+	}
+}
+
+// Dial connects to the server at the specified address.
+func Dial(address string, config Config) (*Client, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(conn, config), nil
+}
+
+// Close closes the connection.
+func (client *Client) Close() error {
+	client.stateLock.Lock()
+	client.closed = true
+	client.stateLock.Unlock()
+	return client.conn.Close()
+}
+
+// AddHandler registers an event handler for the specified event type.
+// Handlers are called from the goroutine that reads the packets.
+func (client *Client) AddHandler(eventType int, handler mcc.EventHandler) {
+	client.handlersLock.Lock()
+	client.handlers[eventType] = append(client.handlers[eventType], handler)
+	client.handlersLock.Unlock()
+}
+
+func (client *Client) fireEvent(eventType int, event interface{}) {
+	client.handlersLock.RLock()
+	for _, handler := range client.handlers[eventType] {
+		handler(eventType, event)
+	}
+	client.handlersLock.RUnlock()
+}
+
+// Supports reports whether the client and the server have negotiated the
+// specified extension.
+func (client *Client) Supports(extension int) bool {
+	return client.cpe[extension]
+}
+
+// ServerName returns the name of the server.
+func (client *Client) ServerName() string {
+	return client.serverName
+}
+
+// MOTD returns the last message of the day sent by the server.
+func (client *Client) MOTD() string {
+	return client.motd
+}
+
+// Level returns the current level, or nil if no level has been received.
+func (client *Client) Level() *mcc.Level {
+	client.levelLock.RLock()
+	defer client.levelLock.RUnlock()
+	return client.level
+}
+
+// Location returns the location of the client.
+func (client *Client) Location() mcc.Location {
+	client.locationLock.RLock()
+	defer client.locationLock.RUnlock()
+	return client.location
+}
+
+// Entity returns the entity with the specified ID, or nil if it is not
+// spawned.
+func (client *Client) Entity(id byte) *Entity {
+	client.entitiesLock.RLock()
+	defer client.entitiesLock.RUnlock()
+	return client.entities[id]
+}
+
+// ForEachEntity calls fn for each spawned entity, excluding the client.
+func (client *Client) ForEachEntity(fn func(*Entity)) {
+	client.entitiesLock.RLock()
+	for _, entity := range client.entities {
+		fn(entity)
+	}
+	client.entitiesLock.RUnlock()
+}
+
+func (client *Client) sendPacket(packet packet) error {
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	_, err := client.conn.Write(packet.Bytes())
+	return err
+}
+
+// Login sends the identification of the client and negotiates the CPE
+// extensions. It returns after the server has accepted the client.
+func (client *Client) Login() error {
+	var packet packet
+	packet.identification(client.config.Name, client.config.Key, len(client.extensions) > 0)
+	if err := client.sendPacket(packet); err != nil {
+		return err
+	}
+
+	for !client.loggedIn {
+		if err := client.readPacket(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run reads and handles packets until the connection is closed. It returns
+// nil if the client was closed or kicked.
+func (client *Client) Run() error {
+	for {
+		if err := client.readPacket(); err != nil {
+			client.stateLock.RLock()
+			closed := client.closed || client.kickReason != ""
+			client.stateLock.RUnlock()
+			if err == io.EOF || closed {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// KickReason returns the reason given by the server for kicking the client.
+func (client *Client) KickReason() string {
+	client.stateLock.RLock()
+	defer client.stateLock.RUnlock()
+	return client.kickReason
+}
+
+// SendMessage sends a chat message. Long messages are split if the server
+// supports the LongerMessages extension, and truncated otherwise.
+func (client *Client) SendMessage(message string) error {
+	var packet packet
+	if client.cpe[mcc.CpeLongerMessages] {
+		for len(message) > 64 {
+			packet.message(true, message[:64])
+			message = message[64:]
+		}
+	} else if len(message) > 64 {
+		message = message[:64]
+	}
+
+	packet.message(false, message)
+	return client.sendPacket(packet)
+}
+
+// SendPluginMessage sends a plugin message on the specified channel.
+func (client *Client) SendPluginMessage(channel byte, data []byte) error {
+	var packet packet
+	packet.PluginMessage(channel, data)
+	return client.sendPacket(packet)
+}
+
+// SetBlock places block at the specified coordinates, or breaks the block if
+// it is air.
+func (client *Client) SetBlock(x, y, z int, block uint16) error {
+	mode := byte(1)
+	if block == mcc.BlockAir {
+		mode = 0
+		block = client.heldBlock
+	} else {
+		client.heldBlock = block
+	}
+
+	var packet packet
+	packet.setBlock(x, y, z, mode, block, client.cpe[mcc.CpeExtendedBlocks])
+	return client.sendPacket(packet)
+}
+
+// Move sends the location of the client to the server.
+func (client *Client) Move(location mcc.Location) error {
+	client.locationLock.Lock()
+	client.location = location
+	client.locationLock.Unlock()
+
+	heldBlock := uint16(SelfID)
+	if client.cpe[mcc.CpeHeldBlock] {
+		heldBlock = client.heldBlock
+	}
+
+	var packet packet
+	packet.teleport(heldBlock, location, client.cpe[mcc.CpeExtEntityPositions], client.cpe[mcc.CpeExtendedBlocks])
+	return client.sendPacket(packet)
+}
+
+func (client *Client) readPacket() error {
+	id, err := client.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	size := proto.ServerPacketSize(id, client.layout())
+	if size == 0 {
+		return fmt.Errorf("client: unknown packet 0x%02x", id)
+	}
+
+	buffer := make([]byte, size)
+	buffer[0] = id
+	if _, err := io.ReadFull(client.reader, buffer[1:]); err != nil {
+		return err
+	}
+
+	client.fireEvent(EventTypePacket, &EventPacket{id, buffer})
+
+	reader := bytes.NewReader(buffer)
+	switch id {
+	case proto.PacketTypeIdentification:
+		client.handleIdentification(reader)
+	case proto.PacketTypePing:
+	case proto.PacketTypeLevelInitialize:
+		client.handleLevelInitialize(reader)
+	case proto.PacketTypeLevelDataChunk:
+		client.handleLevelDataChunk(reader)
+	case proto.PacketTypeLevelFinalize:
+		return client.handleLevelFinalize(reader)
+	case proto.PacketTypeSetBlock:
+		client.handleSetBlock(reader)
+	case proto.PacketTypeAddEntity:
+		client.handleAddEntity(reader, false)
+	case proto.PacketTypeExtAddEntity2:
+		client.handleAddEntity(reader, true)
+	case proto.PacketTypePlayerTeleport:
+		client.handleTeleport(reader)
+	case proto.PacketTypePositionOrientationUpdate,
+		proto.PacketTypePositionUpdate,
+		proto.PacketTypeOrientationUpdate:
+		client.handleEntityUpdate(reader, id)
+	case proto.PacketTypeExtEntityTeleport:
+		client.handleExtEntityTeleport(reader)
+	case proto.PacketTypeRemoveEntity:
+		client.handleRemoveEntity(reader)
+	case proto.PacketTypeChangeModel:
+		client.handleChangeModel(reader)
+	case proto.PacketTypeMessage:
+		client.handleMessage(reader)
+	case proto.PacketTypeKick:
+		return client.handleKick(reader)
+	case proto.PacketTypeExtInfo:
+		return client.handleExtInfo(reader)
+	case proto.PacketTypeExtEntry:
+		return client.handleExtEntry(reader)
+	case proto.PacketTypeCustomBlockSupportLevel:
+		var packet packet
+		packet.CustomBlockSupportLevel(1)
+		return client.sendPacket(packet)
+	case proto.PacketTypeHoldThis:
+		reader.ReadByte()
+		client.heldBlock = client.readBlockID(reader)
+	case proto.PacketTypeDefineBlock:
+		reader.ReadByte()
+		client.handleDefineBlock(client.readBlockDefinition(reader, false))
+	case proto.PacketTypeDefineBlockExt:
+		reader.ReadByte()
+		client.handleDefineBlock(client.readBlockDefinition(reader, true))
+	case proto.PacketTypeRemoveBlockDefinition:
+		reader.ReadByte()
+		client.handleDefineBlock(client.readBlockID(reader), nil)
+	case proto.PacketTypeBulkBlockUpdate:
+		client.handleBulkBlockUpdate(reader)
+	case proto.PacketTypeTwoWayPing:
+		return client.handleTwoWayPing(reader)
+	case proto.PacketTypePluginMessage:
+		client.handlePluginMessage(reader)
+	}
+
+	return nil
+}
+
+func (client *Client) handleIdentification(reader io.Reader) {
+	packet := struct {
+		PacketID        byte
+		ProtocolVersion byte
+		Name            [64]byte
+		MOTD            [64]byte
+		UserType        byte
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet)
+
+	client.serverName = proto.TrimString(packet.Name)
+	client.motd = proto.TrimString(packet.MOTD)
+	if !client.loggedIn {
+		client.loggedIn = true
+		client.fireEvent(EventTypeLogin, &EventLogin{client.serverName, client.motd})
+	}
+}
+
+func (client *Client) handleExtInfo(reader io.Reader) error {
+	packet := struct {
+		PacketID       byte
+		AppName        [64]byte
+		ExtensionCount int16
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet)
+
+	client.remExtensions = int(packet.ExtensionCount)
+	if client.remExtensions == 0 {
+		return client.sendExtensions()
+	}
+
+	return nil
+}
+
+func (client *Client) handleExtEntry(reader io.Reader) error {
+	packet := struct {
+		PacketID byte
+		ExtName  [64]byte
+		Version  int32
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet)
+
+	name := proto.TrimString(packet.ExtName)
+	for _, extension := range client.extensions {
+		if extension.Name != name || extension.Version != int(packet.Version) {
+			continue
+		}
+
+		for i, known := range mcc.Extensions {
+			if known == extension {
+				client.cpe[i] = true
+			}
+		}
+	}
+
+	client.remExtensions--
+	if client.remExtensions == 0 {
+		return client.sendExtensions()
+	}
+
+	return nil
+}
+
+func (client *Client) sendExtensions() error {
+	var packet packet
+	packet.ExtInfo(client.config.AppName, len(client.extensions))
+	for i := range client.extensions {
+		packet.ExtEntry(client.extensions[i].Name, client.extensions[i].Version)
+	}
+
+	return client.sendPacket(packet)
+}
+
+func (client *Client) handleKick(reader io.Reader) error {
+	packet := struct {
+		PacketID byte
+		Reason   [64]byte
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet)
+
+	reason := proto.TrimString(packet.Reason)
+	client.stateLock.Lock()
+	client.kickReason = reason
+	client.stateLock.Unlock()
+
+	client.fireEvent(EventTypeKick, &EventKick{reason})
+	client.conn.Close()
+	return errors.New("client: kicked: " + reason)
+}
+
+func (client *Client) handleLevelInitialize(reader io.Reader) {
+	client.levelSize = -1
+	if client.cpe[mcc.CpeFastMap] {
+		packet := struct {
+			PacketID byte
+			Size     int32
+		}{}
+		binary.Read(reader, binary.BigEndian, &packet)
+		client.levelSize = int(packet.Size)
+	}
+
+	client.levelData[0].Reset()
+	client.levelData[1].Reset()
+	client.blockDefs = nil
+
+	client.entitiesLock.Lock()
+	client.entities = make(map[byte]*Entity)
+	client.entitiesLock.Unlock()
+}
+
+func (client *Client) handleLevelDataChunk(reader io.Reader) {
+	packet := struct {
+		PacketID    byte
+		ChunkLength int16
+		ChunkData   [1024]byte
+		Percent     byte
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet)
+
+	length := int(packet.ChunkLength)
+	if length < 0 || length > len(packet.ChunkData) {
+		return
+	}
+
+	// With ExtendedBlocks, a non-zero value identifies the array of the
+	// upper bits instead of the progress.
+	array := 0
+	if client.cpe[mcc.CpeExtendedBlocks] && packet.Percent == 1 {
+		array = 1
+	}
+
+	client.levelData[array].Write(packet.ChunkData[:length])
+}
+
+func (client *Client) handleLevelFinalize(reader io.Reader) error {
+	packet := struct {
+		PacketID byte
+		X, Y, Z  int16
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet)
+
+	level := mcc.NewLevel(client.serverName, int(packet.X), int(packet.Y), int(packet.Z))
+	if level == nil {
+		level = mcc.NewLevel("level", int(packet.X), int(packet.Y), int(packet.Z))
+	}
+
+	data := make([]byte, level.Size())
+	if err := client.decompressBlocks(&client.levelData[0], data); err != nil {
+		return err
+	}
+
+	for i, block := range data {
+		level.Blocks[i] = uint16(block)
+	}
+
+	if client.levelData[1].Len() > 0 {
+		if err := client.decompressBlocks(&client.levelData[1], data); err != nil {
+			return err
+		}
+
+		for i, block := range data {
+			level.Blocks[i] |= uint16(block) << 8
+		}
+	}
+
+	level.MOTD = client.motd
+	level.BlockDefs = client.blockDefs
+	level.Dirty = false
+
+	client.levelLock.Lock()
+	client.level = level
+	client.levelLock.Unlock()
+
+	client.fireEvent(EventTypeLevelLoad, &EventLevelLoad{level})
+	return nil
+}
+
+// decompressBlocks reads one of the block arrays of the level, which is a
+// deflate stream if FastMap is used and a gzip stream prefixed with the level
+// size otherwise.
+func (client *Client) decompressBlocks(levelData *bytes.Buffer, data []byte) error {
+	var blocks io.Reader
+	if client.levelSize >= 0 {
+		blocks = flate.NewReader(levelData)
+	} else {
+		reader, err := gzip.NewReader(levelData)
+		if err != nil {
+			return err
+		}
+
+		var size int32
+		if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+			return err
+		}
+
+		blocks = reader
+	}
+
+	_, err := io.ReadFull(blocks, data)
+	return err
+}
+
+func (client *Client) handleDefineBlock(id uint16, block *mcc.BlockDefinition) {
+	if int(id) >= len(client.blockDefs) {
+		if block == nil {
+			return
+		}
+
+		defs := make([]*mcc.BlockDefinition, mcc.BlockCount)
+		copy(defs, client.blockDefs)
+		client.blockDefs = defs
+	}
+
+	client.blockDefs[id] = block
+
+	client.levelLock.RLock()
+	if client.level != nil {
+		client.level.BlockDefs = client.blockDefs
+	}
+	client.levelLock.RUnlock()
+}
+
+// GetBlock returns the block at the specified coordinates of the current
+// level, or BlockAir if the coordinates are outside of it.
+func (client *Client) GetBlock(x, y, z int) uint16 {
+	client.levelLock.RLock()
+	defer client.levelLock.RUnlock()
+	level := client.level
+	if level == nil || !level.InBounds(x, y, z) {
+		return mcc.BlockAir
+	}
+
+	return level.Blocks[level.Index(x, y, z)]
+}
+
+func (client *Client) setBlock(x, y, z int, block uint16) {
+	client.levelLock.Lock()
+	level := client.level
+	if level == nil || !level.InBounds(x, y, z) {
+		client.levelLock.Unlock()
+		return
+	}
+
+	level.Blocks[level.Index(x, y, z)] = block
+	client.levelLock.Unlock()
+	client.fireEvent(EventTypeSetBlock, &EventSetBlock{x, y, z, block})
+}
+
+func (client *Client) handleSetBlock(reader io.Reader) {
+	packet := struct {
+		PacketID byte
+		X, Y, Z  int16
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet)
+	block := client.readBlockID(reader)
+	client.setBlock(int(packet.X), int(packet.Y), int(packet.Z), block)
+}
+
+func (client *Client) handleBulkBlockUpdate(reader io.Reader) {
+	packet := struct {
+		PacketID byte
+		Count    byte
+		Indices  [256]int32
+		Blocks   [256]byte
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet)
+
+	var upper [64]byte
+	if client.cpe[mcc.CpeExtendedBlocks] {
+		io.ReadFull(reader, upper[:])
+	}
+
+	level := client.Level()
+	if level == nil {
+		return
+	}
+
+	count := int(packet.Count) + 1
+	for i := 0; i < count; i++ {
+		index := int(packet.Indices[i])
+		if index < 0 || index >= level.Size() {
+			continue
+		}
+
+		block := uint16(packet.Blocks[i]) | uint16(upper[i/4]>>(uint(i%4)*2)&3)<<8
+		x, y, z := level.Position(index)
+		client.setBlock(x, y, z, block)
+	}
+}
+
+func (client *Client) handleAddEntity(reader io.Reader, ext bool) {
+	packet := struct {
+		PacketID byte
+		EntityID byte
+		Name     [64]byte
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet)
+
+	entity := &Entity{
+		ID:    packet.EntityID,
+		Name:  proto.TrimString(packet.Name),
+		Model: mcc.ModelHumanoid,
+	}
+
+	entity.SkinName = entity.Name
+	if ext {
+		var skinName [64]byte
+		binary.Read(reader, binary.BigEndian, &skinName)
+		entity.SkinName = proto.TrimString(skinName)
+	}
+
+	entity.Location = client.readLocation(reader)
+	if entity.ID == SelfID {
+		client.locationLock.Lock()
+		client.location = entity.Location
+		client.locationLock.Unlock()
+	}
+
+	client.entitiesLock.Lock()
+	client.entities[entity.ID] = entity
+	client.entitiesLock.Unlock()
+
+	client.fireEvent(EventTypeEntityAdd, &EventEntityAdd{entity})
+}
+
+func (client *Client) handleRemoveEntity(reader io.Reader) {
+	packet := struct{ PacketID, EntityID byte }{}
+	binary.Read(reader, binary.BigEndian, &packet)
+
+	client.entitiesLock.Lock()
+	entity := client.entities[packet.EntityID]
+	delete(client.entities, packet.EntityID)
+	client.entitiesLock.Unlock()
+
+	if entity != nil {
+		client.fireEvent(EventTypeEntityRemove, &EventEntityRemove{entity})
+	}
+}
+
+func (client *Client) moveEntity(id byte, location mcc.Location) {
+	if id == SelfID {
+		client.locationLock.Lock()
+		client.location = location
+		client.locationLock.Unlock()
+	}
+
+	entity := client.Entity(id)
+	if entity == nil {
+		return
+	}
+
+	from := entity.Location
+	entity.Location = location
+	client.fireEvent(EventTypeEntityMove, &EventEntityMove{entity, from, location})
+}
+
+func (client *Client) handleTeleport(reader io.Reader) {
+	packet := struct{ PacketID, EntityID byte }{}
+	binary.Read(reader, binary.BigEndian, &packet)
+	client.moveEntity(packet.EntityID, client.readLocation(reader))
+}
+
+func (client *Client) handleExtEntityTeleport(reader io.Reader) {
+	packet := struct{ PacketID, EntityID, Flags byte }{}
+	binary.Read(reader, binary.BigEndian, &packet)
+	offset := client.readLocation(reader)
+
+	location := client.Location()
+	if packet.EntityID != SelfID {
+		entity := client.Entity(packet.EntityID)
+		if entity == nil {
+			return
+		}
+
+		location = entity.Location
+	}
+
+	// Bit 0 enables the position and bit 4 the orientation. Bits 1 and 2
+	// select the mode, of which the upper two are relative.
+	if packet.Flags&(1<<0) != 0 {
+		if packet.Flags&(2<<1) != 0 {
+			location.X += offset.X
+			location.Y += offset.Y
+			location.Z += offset.Z
+		} else {
+			location.X, location.Y, location.Z = offset.X, offset.Y, offset.Z
+		}
+	}
+
+	if packet.Flags&(1<<4) != 0 {
+		location.Yaw, location.Pitch = offset.Yaw, offset.Pitch
+	}
+
+	client.moveEntity(packet.EntityID, location)
+}
+
+func (client *Client) handleEntityUpdate(reader io.Reader, id byte) {
+	var packet0 struct{ PacketID, EntityID byte }
+	binary.Read(reader, binary.BigEndian, &packet0)
+
+	entity := client.Entity(packet0.EntityID)
+	if entity == nil {
+		return
+	}
+
+	location := entity.Location
+	if id != proto.PacketTypeOrientationUpdate {
+		var delta struct{ X, Y, Z int8 }
+		binary.Read(reader, binary.BigEndian, &delta)
+		location.X += float64(delta.X) / 32
+		location.Y += float64(delta.Y) / 32
+		location.Z += float64(delta.Z) / 32
+	}
+
+	if id != proto.PacketTypePositionUpdate {
+		var orientation struct{ Yaw, Pitch byte }
+		binary.Read(reader, binary.BigEndian, &orientation)
+		location.Yaw = float64(orientation.Yaw) * 360 / 256
+		location.Pitch = float64(orientation.Pitch) * 360 / 256
+	}
+
+	client.moveEntity(packet0.EntityID, location)
+}
+
+func (client *Client) handleChangeModel(reader io.Reader) {
+	packet := struct {
+		PacketID  byte
+		EntityID  byte
+		ModelName [64]byte
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet)
+
+	if entity := client.Entity(packet.EntityID); entity != nil {
+		entity.Model = proto.TrimString(packet.ModelName)
+	}
+}
+
+func (client *Client) handleMessage(reader io.Reader) {
+	packet := struct {
+		PacketID byte
+		Type     byte
+		Message  [64]byte
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet)
+
+	event := EventMessage{int(packet.Type), proto.TrimString(packet.Message)}
+	if !client.cpe[mcc.CpeMessageTypes] {
+		event.Type = mcc.MessageChat
+	}
+
+	client.fireEvent(EventTypeMessage, &event)
+}
+
+func (client *Client) handleTwoWayPing(reader io.Reader) error {
+	packet0 := struct {
+		PacketID  byte
+		Direction byte
+		Data      int16
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet0)
+
+	if packet0.Direction != 1 {
+		return nil
+	}
+
+	var packet1 packet
+	packet1.TwoWayPing(1, packet0.Data)
+	return client.sendPacket(packet1)
+}
+
+func (client *Client) handlePluginMessage(reader io.Reader) {
+	packet := struct {
+		PacketID byte
+		Channel  byte
+		Data     [64]byte
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet)
+	client.fireEvent(EventTypePluginMessage, &EventPluginMessage{packet.Channel, packet.Data[:]})
+}
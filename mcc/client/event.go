@@ -0,0 +1,75 @@
+package client
+
+import "github.com/AndreasGoulas/go-mcc/mcc"
+
+const (
+	EventTypePacket = iota
+	EventTypeLogin
+	EventTypeKick
+	EventTypeLevelLoad
+	EventTypeSetBlock
+	EventTypeEntityAdd
+	EventTypeEntityRemove
+	EventTypeEntityMove
+	EventTypeMessage
+	EventTypePluginMessage
+)
+
+// EventPacket is dispatched for every packet that is received, before it is
+// handled by the client. Data holds the complete packet, including its ID.
+type EventPacket struct {
+	ID   byte
+	Data []byte
+}
+
+// EventLogin is dispatched when the server accepts the identification of
+// the client.
+type EventLogin struct {
+	ServerName string
+	MOTD       string
+}
+
+// EventKick is dispatched when the client is kicked by the server.
+type EventKick struct {
+	Reason string
+}
+
+// EventLevelLoad is dispatched when a level has been received.
+type EventLevelLoad struct {
+	Level *mcc.Level
+}
+
+// EventSetBlock is dispatched when a block of the level is changed by the
+// server.
+type EventSetBlock struct {
+	X, Y, Z int
+	Block   uint16
+}
+
+// EventEntityAdd is dispatched when an entity is spawned.
+type EventEntityAdd struct {
+	Entity *Entity
+}
+
+// EventEntityRemove is dispatched when an entity is despawned.
+type EventEntityRemove struct {
+	Entity *Entity
+}
+
+// EventEntityMove is dispatched when an entity moves.
+type EventEntityMove struct {
+	Entity   *Entity
+	From, To mcc.Location
+}
+
+// EventMessage is dispatched when a chat message is received.
+type EventMessage struct {
+	Type    int
+	Message string
+}
+
+// EventPluginMessage is dispatched when a plugin message is received.
+type EventPluginMessage struct {
+	Channel byte
+	Data    []byte
+}
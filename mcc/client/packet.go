@@ -0,0 +1,148 @@
+package client
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+	"github.com/AndreasGoulas/go-mcc/mcc/internal/proto"
+)
+
+type packet struct {
+	proto.Packet
+}
+
+func (packet *packet) identification(name, key string, cpe bool) {
+	padding := byte(0x00)
+	if cpe {
+		padding = 0x42
+	}
+
+	packet.Identification(name, key, padding)
+}
+
+func (packet *packet) setBlock(x, y, z int, mode byte, block uint16, extBlocks bool) {
+	packet.Marshal(struct {
+		PacketID byte
+		X, Y, Z  int16
+		Mode     byte
+	}{proto.PacketTypeSetBlockClient, int16(x), int16(y), int16(z), mode})
+	packet.BlockID(block, extBlocks)
+}
+
+func (packet *packet) teleport(heldBlock uint16, location mcc.Location, extPos, extBlocks bool) {
+	packet.WriteByte(proto.PacketTypePlayerTeleport)
+	packet.BlockID(heldBlock, extBlocks)
+	packet.Position(location.X, location.Y, location.Z, extPos)
+	packet.Marshal(struct{ Yaw, Pitch byte }{
+		byte(location.Yaw * 256 / 360),
+		byte(location.Pitch * 256 / 360),
+	})
+}
+
+func (packet *packet) message(partial bool, message string) {
+	// With LongerMessages, a non-zero ID marks a partial message.
+	playerID := byte(0x00)
+	if partial {
+		playerID = 0x01
+	}
+
+	packet.Message(playerID, message)
+}
+
+// layout returns the negotiated extensions that change packet layouts.
+func (client *Client) layout() proto.Extensions {
+	return proto.Extensions{
+		ExtPos:      client.cpe[mcc.CpeExtEntityPositions],
+		ExtBlocks:   client.cpe[mcc.CpeExtendedBlocks],
+		ExtTextures: client.cpe[mcc.CpeExtendedTextures],
+		FastMap:     client.cpe[mcc.CpeFastMap],
+	}
+}
+
+func (client *Client) readBlockID(reader io.Reader) uint16 {
+	return proto.ReadBlockID(reader, client.cpe[mcc.CpeExtendedBlocks])
+}
+
+func (client *Client) readTextureID(reader io.Reader) int {
+	return proto.ReadTextureID(reader, client.cpe[mcc.CpeExtendedTextures])
+}
+
+// readLocation reads an absolute position followed by the orientation.
+func (client *Client) readLocation(reader io.Reader) (location mcc.Location) {
+	location.X, location.Y, location.Z = proto.ReadPosition(reader, client.cpe[mcc.CpeExtEntityPositions])
+
+	orientation := struct{ Yaw, Pitch byte }{}
+	binary.Read(reader, binary.BigEndian, &orientation)
+	location.Yaw = float64(orientation.Yaw) * 360 / 256
+	location.Pitch = float64(orientation.Pitch) * 360 / 256
+	return
+}
+
+// readBlockDefinition reads the body of a DefineBlock or DefineBlockExt
+// packet.
+func (client *Client) readBlockDefinition(reader io.Reader, ext bool) (uint16, *mcc.BlockDefinition) {
+	id := client.readBlockID(reader)
+	packet0 := struct {
+		Name          [64]byte
+		Solidity      byte
+		MovementSpeed byte
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet0)
+
+	block := &mcc.BlockDefinition{
+		Name:        proto.TrimString(packet0.Name),
+		CollideMode: packet0.Solidity,
+		Speed:       math.Pow(2, (float64(packet0.MovementSpeed)-128)/64),
+	}
+
+	block.Textures[mcc.FacePosY] = client.readTextureID(reader)
+	if ext {
+		block.Textures[mcc.FaceNegX] = client.readTextureID(reader)
+		block.Textures[mcc.FacePosX] = client.readTextureID(reader)
+		block.Textures[mcc.FaceNegZ] = client.readTextureID(reader)
+		block.Textures[mcc.FacePosZ] = client.readTextureID(reader)
+	} else {
+		side := client.readTextureID(reader)
+		block.Textures[mcc.FaceNegX] = side
+		block.Textures[mcc.FacePosX] = side
+		block.Textures[mcc.FaceNegZ] = side
+		block.Textures[mcc.FacePosZ] = side
+	}
+	block.Textures[mcc.FaceNegY] = client.readTextureID(reader)
+
+	packet1 := struct {
+		TransmitsLight byte
+		WalkSound      byte
+		FullBright     byte
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet1)
+	block.BlockLight = packet1.TransmitsLight == 0
+	block.WalkSound = packet1.WalkSound
+	block.FullBright = packet1.FullBright != 0
+
+	if ext {
+		aabb := struct {
+			MinX, MinY, MinZ byte
+			MaxX, MaxY, MaxZ byte
+		}{}
+		binary.Read(reader, binary.BigEndian, &aabb)
+		block.Shape = mcc.BlockShapeCube
+		block.AABB.Min = mcc.Vector3{X: int(aabb.MinX), Y: int(aabb.MinY), Z: int(aabb.MinZ)}
+		block.AABB.Max = mcc.Vector3{X: int(aabb.MaxX), Y: int(aabb.MaxY), Z: int(aabb.MaxZ)}
+	} else {
+		binary.Read(reader, binary.BigEndian, &block.Shape)
+	}
+
+	packet2 := struct {
+		BlockDraw        byte
+		FogDensity       byte
+		FogR, FogG, FogB byte
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet2)
+	block.DrawMode = packet2.BlockDraw
+	block.FogDensity = packet2.FogDensity
+	block.Fog = mcc.RGB{R: packet2.FogR, G: packet2.FogG, B: packet2.FogB}
+	return id, block
+}
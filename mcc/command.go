@@ -107,7 +107,7 @@ var DefaultRank = func() (rank Rank) {
 		rank.CanBreak[i] = true
 	}
 
-	banned := []byte{BlockBedrock, BlockActiveWater, BlockWater, BlockActiveLava, BlockLava}
+	banned := []uint16{BlockBedrock, BlockActiveWater, BlockWater, BlockActiveLava, BlockLava}
 	for _, block := range banned {
 		rank.CanPlace[block] = false
 	}
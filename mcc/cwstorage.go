@@ -49,6 +49,12 @@ type cwEnvMapAppearance struct {
 	SideBlock        byte
 	EdgeBlock        byte
 	SideLevel        int16
+
+	// SideBlock2 and EdgeBlock2 hold the upper 8 bits of extended block
+	// IDs, like BlockArray2. They are a Go-MCC extension that other
+	// ClassicWorld readers ignore, and are only written when not zero.
+	SideBlock2 byte `nbt:",omitempty"`
+	EdgeBlock2 byte `nbt:",omitempty"`
 }
 
 type cwEnvWeatherType struct {
@@ -58,6 +64,7 @@ type cwEnvWeatherType struct {
 
 type cwBlockDefinition struct {
 	ID             byte
+	ID2            int16
 	Name           string
 	Speed          float32
 	CollideType    byte
@@ -71,6 +78,16 @@ type cwBlockDefinition struct {
 	Coords         []byte
 }
 
+// id returns the block ID of the definition, preferring the extended ID2
+// field when it is present.
+func (def *cwBlockDefinition) id() int {
+	if def.ID2 > 0 && def.ID2 <= BlockMax {
+		return int(def.ID2)
+	}
+
+	return int(def.ID)
+}
+
 var cwFaceIndices = []int{
 	FacePosY, FaceNegY,
 	FaceNegX, FacePosX,
@@ -108,6 +125,7 @@ type cwLevel struct {
 	TimeCreated   int64
 	Spawn         cwSpawn
 	BlockArray    []byte
+	BlockArray2   []byte `nbt:",omitempty"`
 	Metadata      cwMetadata
 }
 
@@ -179,7 +197,13 @@ func (storage *CwStorage) Load(name string) (level *Level, err error) {
 	copy(level.UUID[:], cw.UUID)
 
 	if len(cw.BlockArray) == level.Size() {
-		level.Blocks = cw.BlockArray
+		extBlocks := len(cw.BlockArray2) == level.Size()
+		for i, block := range cw.BlockArray {
+			level.Blocks[i] = uint16(block)
+			if extBlocks {
+				level.Blocks[i] |= uint16(cw.BlockArray2[i]) << 8
+			}
+		}
 	}
 
 	if cw.TimeCreated > 0 {
@@ -203,8 +227,9 @@ func (storage *CwStorage) Load(name string) (level *Level, err error) {
 
 	if cpe.EnvMapAppearance.ExtensionVersion == 1 {
 		level.EnvConfig.TexturePack = cpe.EnvMapAppearance.TextureURL
-		level.EnvConfig.SideBlock = cpe.EnvMapAppearance.SideBlock
-		level.EnvConfig.EdgeBlock = cpe.EnvMapAppearance.EdgeBlock
+		appearance := cpe.EnvMapAppearance
+		level.EnvConfig.SideBlock = uint16(appearance.SideBlock2)<<8 | uint16(appearance.SideBlock)
+		level.EnvConfig.EdgeBlock = uint16(appearance.EdgeBlock2)<<8 | uint16(appearance.EdgeBlock)
 		level.EnvConfig.EdgeHeight = int(cpe.EnvMapAppearance.SideLevel)
 	}
 
@@ -215,8 +240,8 @@ func (storage *CwStorage) Load(name string) (level *Level, err error) {
 	if cpe.BlockDefinitions.ExtensionVersion == 1 {
 		count := 0
 		for _, v := range cpe.BlockDefinitions.CwBlockDefinitionMap {
-			if int(v.ID) >= count {
-				count = int(v.ID) + 1
+			if v.id() >= count {
+				count = v.id() + 1
 			}
 		}
 
@@ -258,7 +283,7 @@ func (storage *CwStorage) Load(name string) (level *Level, err error) {
 				}
 			}
 
-			level.BlockDefs[v.ID] = def
+			level.BlockDefs[v.id()] = def
 		}
 	}
 
@@ -287,6 +312,7 @@ func (storage *CwStorage) Save(level *Level) (err error) {
 		if v != nil {
 			def := cwBlockDefinition{
 				ID:             byte(i),
+				ID2:            int16(i),
 				Name:           v.Name,
 				Speed:          float32(v.Speed),
 				CollideType:    v.CollideMode,
@@ -335,14 +361,28 @@ func (storage *CwStorage) Save(level *Level) (err error) {
 		cwEnvMapAppearance{
 			1,
 			level.EnvConfig.TexturePack,
-			level.EnvConfig.SideBlock,
-			level.EnvConfig.EdgeBlock,
+			byte(level.EnvConfig.SideBlock),
+			byte(level.EnvConfig.EdgeBlock),
 			int16(level.EnvConfig.EdgeHeight),
+			byte(level.EnvConfig.SideBlock >> 8),
+			byte(level.EnvConfig.EdgeBlock >> 8),
 		},
 		cwEnvWeatherType{1, level.EnvConfig.Weather},
 		cwBlockDefinitions{1, defs},
 	}
 
+	blockArray := make([]byte, len(level.Blocks))
+	var blockArray2 []byte
+	for i, block := range level.Blocks {
+		blockArray[i] = byte(block)
+		if block > BlockMaxDefinitions {
+			if blockArray2 == nil {
+				blockArray2 = make([]byte, len(level.Blocks))
+			}
+			blockArray2[i] = byte(block >> 8)
+		}
+	}
+
 	return NbtMarshal(writer, "ClassicWorld", cwLevel{
 		1,
 		level.Name,
@@ -358,7 +398,8 @@ func (storage *CwStorage) Save(level *Level) (err error) {
 			byte(level.Spawn.Yaw * 256 / 360),
 			byte(level.Spawn.Pitch * 256 / 360),
 		},
-		level.Blocks,
+		blockArray,
+		blockArray2,
 		cwMetadata{
 			level.Metadata,
 			cpe,
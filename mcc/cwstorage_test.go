@@ -0,0 +1,148 @@
+package mcc_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+)
+
+func TestCwEnvBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cwstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := mcc.NewCwStorage(dir + "/")
+	level := mcc.NewLevel("env", 16, 16, 16)
+	level.EnvConfig.SideBlock = 300
+	level.EnvConfig.EdgeBlock = mcc.BlockStone
+	if err := storage.Save(level); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := storage.Load("env")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.EnvConfig.SideBlock != 300 || loaded.EnvConfig.EdgeBlock != mcc.BlockStone {
+		t.Fatalf("SideBlock = %d, EdgeBlock = %d, want 300, %d",
+			loaded.EnvConfig.SideBlock, loaded.EnvConfig.EdgeBlock, mcc.BlockStone)
+	}
+}
+
+func TestCwExtendedBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cwstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := mcc.NewCwStorage(dir + "/")
+	level := mcc.NewLevel("ext", 16, 16, 16)
+	level.BlockDefs = make([]*mcc.BlockDefinition, 768)
+	level.BlockDefs[767] = &mcc.BlockDefinition{Name: "Marble"}
+	level.SetBlockFast(1, 2, 3, 767)
+	level.SetBlockFast(4, 5, 6, 300)
+	level.SetBlockFast(7, 8, 9, mcc.BlockStone)
+	if err := storage.Save(level); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := storage.Load("ext")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, block := range level.Blocks {
+		if loaded.Blocks[i] != block {
+			t.Fatalf("Blocks[%d] = %d, want %d", i, loaded.Blocks[i], block)
+		}
+	}
+
+	if def := loaded.BlockDef(767); def == nil || def.Name != "Marble" {
+		t.Errorf("BlockDef(767) = %+v, want Marble", def)
+	}
+}
+
+func TestCwOmitExtendedTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cwstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := mcc.NewCwStorage(dir + "/")
+	level := mcc.NewLevel("classic", 16, 16, 16)
+	level.SetBlockFast(1, 2, 3, mcc.BlockStone)
+	if err := storage.Save(level); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(dir + "/classic.cw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tag := range []string{"BlockArray2", "SideBlock2", "EdgeBlock2"} {
+		if bytes.Contains(data, []byte(tag)) {
+			t.Errorf("%s was written for a level without extended blocks", tag)
+		}
+	}
+}
+
+func TestLvlExtendedBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lvlstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := mcc.NewLvlStorage(dir + "/")
+	level := mcc.NewLevel("ext", 16, 16, 16)
+	level.BlockDefs = make([]*mcc.BlockDefinition, 301)
+	level.BlockDefs[300] = &mcc.BlockDefinition{Name: "Marble", Fallback: mcc.BlockStone}
+	level.SetBlockFast(1, 2, 3, 300)
+	level.SetBlockFast(4, 5, 6, 301)
+	level.SetBlockFast(7, 8, 9, mcc.BlockGlass)
+	if err := storage.Save(level); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := storage.Load("ext")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The format only stores 8-bit blocks, so extended blocks are saved as
+	// their fallback, or as air if they have none.
+	tests := []struct {
+		x, y, z int
+		block   uint16
+	}{
+		{1, 2, 3, mcc.BlockStone},
+		{4, 5, 6, mcc.BlockAir},
+		{7, 8, 9, mcc.BlockGlass},
+	}
+	for _, test := range tests {
+		if block := loaded.GetBlock(test.x, test.y, test.z); block != test.block {
+			t.Errorf("GetBlock(%d, %d, %d) = %d, want %d", test.x, test.y, test.z, block, test.block)
+		}
+	}
+}
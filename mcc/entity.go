@@ -2,6 +2,8 @@ package mcc
 
 import (
 	"math"
+	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -20,6 +22,18 @@ const (
 	ModelChibi     = "chibi"
 )
 
+const (
+	// TeleportKeepRotation keeps the current yaw and pitch of the entity.
+	TeleportKeepRotation = 1 << 0
+
+	// TeleportRelative treats the coordinates of the location as an offset
+	// from the current position.
+	TeleportRelative = 1 << 1
+
+	// TeleportInterpolate moves the entity smoothly instead of snapping.
+	TeleportInterpolate = 1 << 2
+)
+
 // EntityProps holds various entity properties.
 type EntityProps struct {
 	RotX, RotY, RotZ       float64
@@ -42,7 +56,7 @@ type Entity struct {
 	server *Server
 	player *Player
 
-	id   byte
+	id   uint32
 	name string
 
 	Model string
@@ -58,6 +72,10 @@ type Entity struct {
 	level        *Level
 	location     Location
 	lastLocation Location
+
+	teleportFlags   byte
+	teleportPending bool
+	locationLock    sync.RWMutex
 }
 
 // NewEntity creates a new Entity with the specified name.
@@ -78,8 +96,14 @@ func (entity *Entity) Server() *Server {
 	return entity.server
 }
 
+// ID returns the server-wide ID of the entity. It returns 0xff if the entity
+// has not been added to the server, or if 255 other entities were added
+// before it.
+//
+// Deprecated: Players know entities by different IDs, which are returned by
+// Player.EntityID.
 func (entity *Entity) ID() byte {
-	return entity.id
+	return byte(atomic.LoadUint32(&entity.id))
 }
 
 func (entity *Entity) Name() string {
@@ -88,8 +112,8 @@ func (entity *Entity) Name() string {
 
 // SendModel sends the model of the entity to all relevant players.
 func (entity *Entity) SendModel() {
-	if entity.level != nil {
-		entity.level.ForEachPlayer(func(player *Player) {
+	if level := entity.Level(); level != nil {
+		level.ForEachPlayer(func(player *Player) {
 			player.sendChangeModel(entity)
 		})
 	}
@@ -98,8 +122,8 @@ func (entity *Entity) SendModel() {
 // SendProps sends the EntityProps of the entity to all relevant players.
 // mask controls which properties are sent.
 func (entity *Entity) SendProps(mask uint32) {
-	if entity.level != nil {
-		entity.level.ForEachPlayer(func(player *Player) {
+	if level := entity.Level(); level != nil {
+		level.ForEachPlayer(func(player *Player) {
 			player.sendEntityProps(entity, mask)
 		})
 	}
@@ -113,40 +137,135 @@ func (entity *Entity) SendListName() {
 }
 
 func (entity *Entity) Location() Location {
+	entity.locationLock.RLock()
+	defer entity.locationLock.RUnlock()
 	return entity.location
 }
 
+func (entity *Entity) setLocation(location Location) {
+	entity.locationLock.Lock()
+	entity.location = location
+	entity.locationLock.Unlock()
+}
+
+// moved reports whether the entity has moved since the last update.
+func (entity *Entity) moved() bool {
+	entity.locationLock.RLock()
+	defer entity.locationLock.RUnlock()
+	return entity.location != entity.lastLocation
+}
+
 // Teleport teleports the entity to location.
 func (entity *Entity) Teleport(location Location) {
-	if location == entity.location {
+	from := entity.Location()
+	if location == from {
 		return
 	}
 
-	event := &EventEntityMove{entity, entity.location, location, false}
+	event := EventEntityMove{entity, from, location, false}
 	entity.server.FireEvent(EventTypeEntityMove, &event)
 	if event.Cancel {
 		return
 	}
 
+	entity.locationLock.Lock()
 	entity.location = location
+	entity.teleportPending = false
+	entity.locationLock.Unlock()
 	if entity.player != nil {
+		entity.player.allowMovement(movementGrace)
 		entity.player.sendTeleport(entity)
 	}
 }
 
+// TeleportExt teleports the entity to location. flags is a combination of
+// TeleportKeepRotation, TeleportRelative and TeleportInterpolate.
+// Clients that do not support the ExtEntityTeleport extension see a regular
+// teleport.
+func (entity *Entity) TeleportExt(location Location, flags byte) {
+	from := entity.Location()
+	target := location
+	if flags&TeleportRelative != 0 {
+		target.X += from.X
+		target.Y += from.Y
+		target.Z += from.Z
+	}
+	if flags&TeleportKeepRotation != 0 {
+		target.Yaw = from.Yaw
+		target.Pitch = from.Pitch
+	}
+
+	if target == from {
+		return
+	}
+
+	event := EventEntityMove{entity, from, target, false}
+	entity.server.FireEvent(EventTypeEntityMove, &event)
+	if event.Cancel {
+		return
+	}
+
+	entity.locationLock.Lock()
+	last := entity.location
+	entity.location = target
+	entity.teleportFlags = flags
+	entity.teleportPending = true
+	entity.locationLock.Unlock()
+	if entity.player != nil {
+		entity.player.allowMovement(movementGrace)
+		entity.player.sendTeleportExt(entity, flags, last)
+	}
+}
+
+// extTeleport returns the flags and location of an ExtEntityTeleport packet
+// that moves an entity from last to location.
+func extTeleport(flags byte, location, last Location) (byte, Location) {
+	var extFlags byte = extTeleportUsePosition
+	if flags&TeleportRelative != 0 {
+		location.X = float64(int32(location.X*32)-int32(last.X*32)) / 32
+		location.Y = float64(int32(location.Y*32)-int32(last.Y*32)) / 32
+		location.Z = float64(int32(location.Z*32)-int32(last.Z*32)) / 32
+		if flags&TeleportInterpolate != 0 {
+			extFlags |= extTeleportRelativeSmooth
+		} else {
+			extFlags |= extTeleportRelativeSeamless
+		}
+	} else if flags&TeleportInterpolate != 0 {
+		extFlags |= extTeleportAbsoluteSmooth
+	}
+
+	if flags&TeleportKeepRotation == 0 {
+		extFlags |= extTeleportUseOrientation
+		if flags&TeleportInterpolate != 0 {
+			extFlags |= extTeleportInterpolateRotation
+		}
+	}
+
+	return extFlags, location
+}
+
 func (entity *Entity) Level() *Level {
+	entity.locationLock.RLock()
+	defer entity.locationLock.RUnlock()
 	return entity.level
 }
 
+func (entity *Entity) setLevel(level *Level) {
+	entity.locationLock.Lock()
+	entity.level = level
+	entity.locationLock.Unlock()
+}
+
 // TeleportLevel teleports the entity to the spawn location of level.
 func (entity *Entity) TeleportLevel(level *Level) {
-	if entity.level == level {
+	lastLevel := entity.Level()
+	if lastLevel == level {
 		return
 	}
 
-	lastLevel := entity.level
 	if lastLevel != nil {
-		entity.level = nil
+		entity.setLevel(nil)
+		lastLevel.removeEntity(entity)
 		entity.despawn(lastLevel)
 		if entity.player != nil {
 			entity.player.despawnLevel(lastLevel)
@@ -154,87 +273,134 @@ func (entity *Entity) TeleportLevel(level *Level) {
 	}
 
 	if level != nil {
+		entity.locationLock.Lock()
 		entity.location = level.Spawn
 		entity.lastLocation = entity.location
+		entity.teleportPending = false
+		entity.locationLock.Unlock()
 		if entity.player != nil {
 			entity.player.spawnLevel(level)
 		}
 
 		entity.spawn(level)
+		level.addEntity(entity)
 	}
 
-	entity.level = level
+	entity.setLevel(level)
 
 	event := EventEntityLevelChange{entity, lastLevel, level}
 	entity.server.FireEvent(EventTypeEntityLevelChange, &event)
 }
 
 func (entity *Entity) update() {
-	if entity.level == nil {
+	entity.locationLock.Lock()
+	level := entity.level
+	location, lastLocation := entity.location, entity.lastLocation
+	pending := entity.teleportPending
+	teleportFlags := entity.teleportFlags
+	entity.lastLocation = entity.location
+	entity.teleportPending = false
+	entity.locationLock.Unlock()
+
+	if level == nil {
 		return
 	}
 
 	positionDirty := false
-	if entity.location.X != entity.lastLocation.X ||
-		entity.location.Y != entity.lastLocation.Y ||
-		entity.location.Z != entity.lastLocation.Z {
+	if location.X != lastLocation.X ||
+		location.Y != lastLocation.Y ||
+		location.Z != lastLocation.Z {
 		positionDirty = true
 	}
 
 	rotationDirty := false
-	if entity.location.Yaw != entity.lastLocation.Yaw ||
-		entity.location.Pitch != entity.lastLocation.Pitch {
+	if location.Yaw != lastLocation.Yaw ||
+		location.Pitch != lastLocation.Pitch {
 		rotationDirty = true
 	}
 
 	teleport := false
-	if math.Abs(entity.location.X-entity.lastLocation.X) > 1.0 ||
-		math.Abs(entity.location.Y-entity.lastLocation.Y) > 1.0 ||
-		math.Abs(entity.location.Z-entity.lastLocation.Z) > 1.0 {
+	if math.Abs(location.X-lastLocation.X) > 1.0 ||
+		math.Abs(location.Y-lastLocation.Y) > 1.0 ||
+		math.Abs(location.Z-lastLocation.Z) > 1.0 {
 		teleport = true
 	}
 
-	var packet, packetExt packet
-	if teleport {
-		packet.teleport(entity, false, false)
-		packetExt.teleport(entity, false, true)
-	} else if positionDirty && rotationDirty {
-		packet.positionOrientationUpdate(entity)
-	} else if positionDirty {
-		packet.positionUpdate(entity)
-	} else if rotationDirty {
-		packet.orientationUpdate(entity)
-	} else {
+	var extFlags byte
+	var extLocation Location
+	if pending {
+		extFlags, extLocation = extTeleport(teleportFlags, location, lastLocation)
+	}
+
+	if !teleport && !positionDirty && !rotationDirty {
 		return
 	}
 
-	entity.lastLocation = entity.location
-	entity.level.ForEachPlayer(func(player *Player) {
-		if player.Entity != entity {
-			if teleport && player.cpe[CpeExtEntityPositions] {
-				player.sendPacket(packetExt)
-			} else {
-				player.sendPacket(packet)
+	if positionDirty && entity.player != nil {
+		entity.player.updateView(level)
+	}
+
+	level.ForEachPlayer(func(player *Player) {
+		if player.Entity == entity {
+			return
+		}
+
+		id, ok := player.entityIDs.id(entity)
+		if !player.inView(entity, level) {
+			player.sendDespawn(entity)
+			return
+		} else if !ok {
+			if level.viewDistance() > 0 {
+				player.sendSpawn(entity)
 			}
+			return
+		}
+
+		var packet packet
+		extPos := player.cpe[CpeExtEntityPositions]
+		switch {
+		case pending && player.cpe[CpeExtEntityTeleport]:
+			packet.extEntityTeleport(id, extFlags, extLocation, extPos)
+		case teleport:
+			packet.teleport(id, location, extPos)
+		case positionDirty && rotationDirty:
+			packet.positionOrientationUpdate(id, location, lastLocation)
+		case positionDirty:
+			packet.positionUpdate(id, location, lastLocation)
+		default:
+			packet.orientationUpdate(id, location)
 		}
+
+		player.sendPacket(packet)
 	})
 }
 
 // Respawn respawns the entity to all relevant players.
 func (entity *Entity) Respawn() {
-	if entity.level == nil {
+	level := entity.Level()
+	if level == nil {
 		return
 	}
 
-	entity.despawn(entity.level)
-	entity.location = entity.level.Spawn
-	entity.lastLocation = entity.location
-	entity.spawn(entity.level)
+	entity.despawn(level)
+	location := level.Spawn
+	if entity.player != nil {
+		location = entity.player.SpawnLocation()
+	}
+
+	entity.locationLock.Lock()
+	entity.location = location
+	entity.lastLocation = location
+	entity.teleportPending = false
+	entity.locationLock.Unlock()
+	entity.spawn(level)
 }
 
 func (entity *Entity) spawn(level *Level) {
 	level.ForEachPlayer(func(player *Player) {
-		player.sendSpawn(entity)
+		if player.inView(entity, level) {
+			player.sendSpawn(entity)
+		}
 	})
 }
 
@@ -0,0 +1,151 @@
+package mcc_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+	"github.com/AndreasGoulas/go-mcc/mcc/client"
+	"github.com/AndreasGoulas/go-mcc/mcc/mcctest"
+)
+
+// countEntities returns the number of entities other than itself that the
+// player can see.
+func countEntities(player *mcctest.Player) (count int) {
+	player.ForEachEntity(func(entity *client.Entity) {
+		if entity.ID != client.SelfID {
+			count++
+		}
+	})
+
+	return
+}
+
+func TestEntityIDs(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	server := harness.Server
+	other := mcc.NewLevel("other", 32, 16, 32)
+	server.AddLevel(other)
+
+	var bots []*mcc.Entity
+	for i := 0; i < 400; i++ {
+		bot := mcc.NewEntity(fmt.Sprintf("bot%d", i), server)
+		server.AddEntity(bot)
+		if i%2 == 0 {
+			bot.TeleportLevel(server.MainLevel)
+		} else {
+			bot.TeleportLevel(other)
+		}
+		bots = append(bots, bot)
+	}
+
+	alice := harness.Connect("alice", nil)
+	bob := harness.Connect("bob", mcctest.NoExtensions)
+	server.FindPlayer("bob").TeleportLevel(other)
+
+	alice.WaitUntil(func() bool { return countEntities(alice) == 200 })
+	bob.WaitUntil(func() bool {
+		return bob.Level().Width == 32 && countEntities(bob) == 200
+	})
+
+	player := server.FindPlayer("alice")
+	for i, bot := range bots {
+		id, ok := player.EntityID(bot)
+		if ok != (i%2 == 0) {
+			t.Fatalf("EntityID(%s) = %d, %v", bot.Name(), id, ok)
+		}
+		if ok && player.FindEntityByID(id) != bot {
+			t.Fatalf("FindEntityByID(%d) != %s", id, bot.Name())
+		}
+	}
+
+	bots[0].TeleportLevel(other)
+	alice.WaitUntil(func() bool { return countEntities(alice) == 199 })
+	bob.WaitUntil(func() bool { return countEntities(bob) == 201 })
+}
+
+func TestViewDistance(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	server := harness.Server
+	level := mcc.NewLevel("big", 128, 16, 128)
+	level.ViewDistance = 16
+	server.AddLevel(level)
+
+	near := mcc.NewEntity("near", server)
+	server.AddEntity(near)
+	near.TeleportLevel(level)
+
+	far := mcc.NewEntity("far", server)
+	server.AddEntity(far)
+	far.TeleportLevel(level)
+	far.Teleport(mcc.Location{X: 8, Y: 12, Z: 8})
+
+	alice := harness.Connect("alice", nil)
+	server.FindPlayer("alice").TeleportLevel(level)
+	alice.WaitUntil(func() bool {
+		return alice.Level().Width == 128 && countEntities(alice) == 1
+	})
+
+	spawn := level.Spawn
+	far.Teleport(mcc.Location{X: spawn.X + 4, Y: spawn.Y, Z: spawn.Z})
+	alice.WaitUntil(func() bool { return countEntities(alice) == 2 })
+
+	far.Teleport(mcc.Location{X: 8, Y: 12, Z: 8})
+	alice.WaitUntil(func() bool { return countEntities(alice) == 1 })
+
+	alice.Move(mcc.Location{X: 10, Y: 12, Z: 10})
+	alice.WaitUntil(func() bool {
+		var name string
+		alice.ForEachEntity(func(entity *client.Entity) {
+			if entity.ID != client.SelfID {
+				name = entity.Name
+			}
+		})
+		return countEntities(alice) == 1 && name == "far"
+	})
+}
+
+func TestEntityServerID(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	server := harness.Server
+	var bots []*mcc.Entity
+	for i := 0; i < 300; i++ {
+		bot := mcc.NewEntity(fmt.Sprintf("bot%d", i), server)
+		if bot.ID() != 0xff {
+			t.Fatalf("ID() = %d before AddEntity, want 255", bot.ID())
+		}
+		server.AddEntity(bot)
+		bots = append(bots, bot)
+	}
+
+	// The deprecated server-wide IDs are only assigned to 255 entities.
+	for i, bot := range bots {
+		want := byte(0xff)
+		if i < 0xff {
+			want = byte(i)
+		}
+		if bot.ID() != want {
+			t.Fatalf("ID() of %s = %d, want %d", bot.Name(), bot.ID(), want)
+		}
+		if i < 0xff && server.FindEntityByID(want) != bot {
+			t.Fatalf("FindEntityByID(%d) != %s", want, bot.Name())
+		}
+	}
+
+	server.RemoveEntity(bots[7])
+	if bots[7].ID() != 0xff {
+		t.Errorf("ID() = %d after RemoveEntity, want 255", bots[7].ID())
+	}
+
+	bot := mcc.NewEntity("late", server)
+	server.AddEntity(bot)
+	if bot.ID() != 7 {
+		t.Errorf("ID() = %d, want the freed ID 7", bot.ID())
+	}
+}
@@ -0,0 +1,80 @@
+package mcc
+
+import "sync"
+
+// entityTable maps the entities that a player can see to the IDs that are
+// used for them in the protocol. Every player has its own table, so the
+// number of entities on the server is not limited by the size of an ID.
+// ID 0xff is reserved for the player itself.
+type entityTable struct {
+	ids      map[*Entity]byte
+	entities [0xff]*Entity
+	lock     sync.Mutex
+}
+
+// add assigns the lowest free ID to entity. It returns the existing ID if
+// entity is already in the table, or false if the table is full.
+func (table *entityTable) add(entity *Entity) (byte, bool) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	if id, ok := table.ids[entity]; ok {
+		return id, true
+	}
+
+	for id, other := range table.entities {
+		if other == nil {
+			if table.ids == nil {
+				table.ids = make(map[*Entity]byte)
+			}
+
+			table.ids[entity] = byte(id)
+			table.entities[id] = entity
+			return byte(id), true
+		}
+	}
+
+	return 0xff, false
+}
+
+// remove removes entity from the table and returns the ID it had.
+func (table *entityTable) remove(entity *Entity) (byte, bool) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	id, ok := table.ids[entity]
+	if ok {
+		delete(table.ids, entity)
+		table.entities[id] = nil
+	}
+
+	return id, ok
+}
+
+// id returns the ID of entity.
+func (table *entityTable) id(entity *Entity) (byte, bool) {
+	table.lock.Lock()
+	id, ok := table.ids[entity]
+	table.lock.Unlock()
+	return id, ok
+}
+
+// entity returns the entity with the specified ID, or nil if the ID is free.
+func (table *entityTable) entity(id byte) *Entity {
+	if id == 0xff {
+		return nil
+	}
+
+	table.lock.Lock()
+	entity := table.entities[id]
+	table.lock.Unlock()
+	return entity
+}
+
+// clear removes all entities from the table.
+func (table *entityTable) clear() {
+	table.lock.Lock()
+	table.ids = nil
+	table.entities = [0xff]*Entity{}
+	table.lock.Unlock()
+}
@@ -25,6 +25,9 @@ const (
 	EventTypeLevelUnload
 	EventTypeLevelSave
 	EventTypeCommand
+	EventTypePluginMessage
+	EventTypeRateLimit
+	EventTypeMovementViolation
 )
 
 // EventHandler is the type of the function called to handle an event.
@@ -90,8 +93,8 @@ type EventEntityMove struct {
 type EventBlockPlace struct {
 	Player   *Player
 	Level    *Level
-	Block    byte
-	OldBlock byte
+	Block    uint16
+	OldBlock uint16
 	X, Y, Z  int
 	Cancel   bool
 }
@@ -101,7 +104,7 @@ type EventBlockPlace struct {
 type EventBlockBreak struct {
 	Player  *Player
 	Level   *Level
-	Block   byte
+	Block   uint16
 	X, Y, Z int
 	Cancel  bool
 }
@@ -128,3 +131,30 @@ type EventCommand struct {
 	Message string
 	Allow   bool
 }
+
+// EventRateLimit is dispatched when a client exceeds a rate limit, before
+// the client is kicked. Player is nil if the connection was rejected before
+// the login.
+type EventRateLimit struct {
+	Player *Player
+	Addr   string
+	Limit  int
+	Reason string
+}
+
+// EventMovementViolation is dispatched when the movement of a player fails
+// the movement checks. If the event is cancelled, the movement is accepted.
+type EventMovementViolation struct {
+	Player    *Player
+	From, To  Location
+	Violation int
+	Cancel    bool
+}
+
+// EventPluginMessage is dispatched when a player sends a plugin message.
+// Data always has a length of 64 bytes.
+type EventPluginMessage struct {
+	Player  *Player
+	Channel byte
+	Data    []byte
+}
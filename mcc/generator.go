@@ -16,8 +16,8 @@ type GeneratorFunc func(args ...string) Generator
 // generate flat grass levels.
 type FlatGenerator struct {
 	GrassHeight  int
-	SurfaceBlock byte
-	SoilBlock    byte
+	SurfaceBlock uint16
+	SoilBlock    uint16
 }
 
 func NewFlatGenerator(args ...string) Generator {
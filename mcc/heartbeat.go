@@ -0,0 +1,165 @@
+package mcc
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var heartbeatClient = &http.Client{Timeout: 10 * time.Second}
+
+// Heartbeat is a server list service that the server announces itself to.
+// Every heartbeat has its own salt, which the service uses to generate the
+// verification keys of players.
+type Heartbeat struct {
+	url  string
+	salt [16]byte
+
+	playURL  string
+	err      error
+	lastSent time.Time
+	lock     sync.Mutex
+}
+
+func newHeartbeat(url string) *Heartbeat {
+	const charset = "abcdefghijklmnopqrstuvwxyz" +
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+		"0123456789"
+
+	heartbeat := &Heartbeat{url: url}
+	for i := range heartbeat.salt {
+		heartbeat.salt[i] = charset[rand.Intn(len(charset))]
+	}
+
+	return heartbeat
+}
+
+// URL returns the URL of the heartbeat service.
+func (heartbeat *Heartbeat) URL() string {
+	return heartbeat.url
+}
+
+// Status returns the play URL returned by the service, the time at which the
+// last heartbeat was sent and its error. lastSent is zero if no heartbeat has
+// been sent yet.
+func (heartbeat *Heartbeat) Status() (playURL string, lastSent time.Time, err error) {
+	heartbeat.lock.Lock()
+	defer heartbeat.lock.Unlock()
+	return heartbeat.playURL, heartbeat.lastSent, heartbeat.err
+}
+
+// verify reports whether key is the verification key of name generated by
+// the service, which is the hex-encoded MD5 hash of the salt and the name.
+func (heartbeat *Heartbeat) verify(name string, key string) bool {
+	data := make([]byte, len(heartbeat.salt))
+	copy(data, heartbeat.salt[:])
+	data = append(data, []byte(name)...)
+
+	digest := md5.Sum(data)
+	return strings.EqualFold(hex.EncodeToString(digest[:]), key)
+}
+
+func (heartbeat *Heartbeat) send(ctx context.Context, server *Server) {
+	playURL, err := heartbeat.post(ctx, server)
+	if ctx.Err() != nil {
+		return
+	}
+
+	heartbeat.lock.Lock()
+	heartbeat.err = err
+	heartbeat.lastSent = time.Now()
+	if err == nil {
+		heartbeat.playURL = playURL
+	}
+	heartbeat.lock.Unlock()
+
+	if err != nil {
+		log.Printf("sendHeartbeat: %s: %s\n", heartbeat.url, err)
+	}
+}
+
+func (heartbeat *Heartbeat) post(ctx context.Context, server *Server) (string, error) {
+	form := url.Values{}
+	form.Add("name", server.Config.Name)
+	form.Add("port", strconv.Itoa(server.port))
+	form.Add("max", strconv.Itoa(server.Config.MaxPlayers))
+	form.Add("users", strconv.Itoa(int(atomic.LoadInt32(&server.playerCount))))
+	form.Add("salt", string(heartbeat.salt[:]))
+	form.Add("version", "7")
+	form.Add("software", ServerSoftware)
+	if server.Config.Public {
+		form.Add("public", "True")
+	} else {
+		form.Add("public", "False")
+	}
+
+	request, err := http.NewRequest("POST", heartbeat.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := heartbeatClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return "", errors.New(response.Status)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return parseHeartbeatResponse(body)
+}
+
+// parseHeartbeatResponse returns the play URL in the response of a heartbeat
+// service. Services respond with the URL on success, or with a JSON object
+// that describes the errors.
+func parseHeartbeatResponse(body []byte) (string, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '{' {
+		data := struct {
+			Status   string     `json:"status"`
+			Response string     `json:"response"`
+			Errors   [][]string `json:"errors"`
+		}{}
+
+		if err := json.Unmarshal(body, &data); err != nil {
+			return "", err
+		}
+
+		if len(data.Errors) > 0 && len(data.Errors[0]) > 0 {
+			return "", errors.New(data.Errors[0][0])
+		} else if data.Status == "fail" {
+			return "", errors.New("heartbeat failed")
+		}
+
+		body = []byte(data.Response)
+	}
+
+	playURL, err := url.Parse(string(body))
+	if err != nil || len(playURL.Scheme) == 0 || len(playURL.Host) == 0 {
+		return "", fmt.Errorf("invalid response %q", body)
+	}
+
+	return playURL.String(), nil
+}
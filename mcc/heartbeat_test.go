@@ -0,0 +1,132 @@
+package mcc_test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+	"github.com/AndreasGoulas/go-mcc/mcc/client"
+	"github.com/AndreasGoulas/go-mcc/mcc/mcctest"
+)
+
+func TestHeartbeats(t *testing.T) {
+	var salt string
+	var saltLock sync.Mutex
+	list := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		saltLock.Lock()
+		salt = r.FormValue("salt")
+		saltLock.Unlock()
+		w.Write([]byte("http://list.example/play/123\n"))
+	}))
+	defer list.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errors":[["Invalid name"]],"response":"","status":"fail"}`))
+	}))
+	defer broken.Close()
+
+	harness := mcctest.NewHarness(t, &mcc.Config{
+		Name:       "Test",
+		MaxPlayers: 16,
+		MainLevel:  "main",
+		Verify:     true,
+		Heartbeat:  list.URL,
+		Heartbeats: []string{broken.URL, list.URL},
+	})
+	defer harness.Close()
+
+	var heartbeats []*mcc.Heartbeat
+	harness.Server.ForEachHeartbeat(func(heartbeat *mcc.Heartbeat) {
+		heartbeats = append(heartbeats, heartbeat)
+	})
+	if len(heartbeats) != 2 {
+		t.Fatalf("heartbeats = %d, want 2", len(heartbeats))
+	}
+
+	deadline := time.Now().Add(mcctest.Timeout)
+	for _, heartbeat := range heartbeats {
+		for {
+			if _, lastSent, _ := heartbeat.Status(); !lastSent.IsZero() {
+				break
+			} else if time.Now().After(deadline) {
+				t.Fatalf("%s: no heartbeat sent", heartbeat.URL())
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if playURL, _, err := heartbeats[0].Status(); err != nil || playURL != "http://list.example/play/123" {
+		t.Fatalf("%s: playURL = %q, err = %v", heartbeats[0].URL(), playURL, err)
+	}
+	if _, _, err := heartbeats[1].Status(); err == nil || err.Error() != "Invalid name" {
+		t.Fatalf("%s: err = %v, want Invalid name", heartbeats[1].URL(), err)
+	}
+
+	saltLock.Lock()
+	digest := md5.Sum([]byte(salt + "alice"))
+	saltLock.Unlock()
+
+	login := func(key string) (*client.Client, error) {
+		conn, err := harness.Listener.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := client.NewClient(conn, client.Config{
+			Name:       "alice",
+			Key:        key,
+			Extensions: mcctest.NoExtensions,
+		})
+		err = c.Login()
+		c.Close()
+		return c, err
+	}
+
+	if c, _ := login(strings.Repeat("0", 32)); c.KickReason() != "Login failed!" {
+		t.Fatalf("kick reason = %q, want Login failed!", c.KickReason())
+	}
+	if _, err := login(hex.EncodeToString(digest[:])); err != nil {
+		t.Fatalf("login with a valid key: %s", err)
+	}
+}
+
+func TestHeartbeatPort(t *testing.T) {
+	ports := make(chan string, 1)
+	list := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case ports <- r.FormValue("port"):
+		default:
+		}
+		w.Write([]byte("http://list.example/play/123\n"))
+	}))
+	defer list.Close()
+
+	_, listeners, stop := startServer(t, &mcc.Config{
+		Port:       25565,
+		Name:       "Test",
+		MaxPlayers: 16,
+		MainLevel:  "main",
+		Heartbeat:  list.URL,
+		Listen:     []string{"127.0.0.1:0"},
+	})
+	defer stop()
+
+	want := strconv.Itoa(listeners[0].Addr().(*net.TCPAddr).Port)
+	select {
+	case port := <-ports:
+		if port != want {
+			t.Errorf("port = %s, want %s", port, want)
+		}
+	case <-time.After(mcctest.Timeout):
+		t.Fatal("no heartbeat sent")
+	}
+}
@@ -0,0 +1,414 @@
+// Package proto holds the parts of the classic protocol that are shared by
+// the server and the client: packet IDs, packet sizes and the layouts of the
+// packets that both sides send.
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"strings"
+)
+
+const (
+	PacketTypeIdentification            = 0x00
+	PacketTypePing                      = 0x01
+	PacketTypeLevelInitialize           = 0x02
+	PacketTypeLevelDataChunk            = 0x03
+	PacketTypeLevelFinalize             = 0x04
+	PacketTypeSetBlockClient            = 0x05
+	PacketTypeSetBlock                  = 0x06
+	PacketTypeAddEntity                 = 0x07
+	PacketTypePlayerTeleport            = 0x08
+	PacketTypePositionOrientationUpdate = 0x09
+	PacketTypePositionUpdate            = 0x0a
+	PacketTypeOrientationUpdate         = 0x0b
+	PacketTypeRemoveEntity              = 0x0c
+	PacketTypeMessage                   = 0x0d
+	PacketTypeKick                      = 0x0e
+	PacketTypeUpdateUserType            = 0x0f
+	PacketTypeExtInfo                   = 0x10
+	PacketTypeExtEntry                  = 0x11
+	PacketTypeSetClickDistance          = 0x12
+	PacketTypeCustomBlockSupportLevel   = 0x13
+	PacketTypeHoldThis                  = 0x14
+	PacketTypeSetTextHotKey             = 0x15
+	PacketTypeExtAddPlayerName          = 0x16
+	PacketTypeExtRemovePlayerName       = 0x18
+	PacketTypeEnvSetColor               = 0x19
+	PacketTypeMakeSelection             = 0x1a
+	PacketTypeRemoveSelection           = 0x1b
+	PacketTypeSetBlockPermission        = 0x1c
+	PacketTypeChangeModel               = 0x1d
+	PacketTypeEnvSetWeatherType         = 0x1f
+	PacketTypeHackControl               = 0x20
+	PacketTypeExtAddEntity2             = 0x21
+	PacketTypePlayerClicked             = 0x22
+	PacketTypeDefineBlock               = 0x23
+	PacketTypeRemoveBlockDefinition     = 0x24
+	PacketTypeDefineBlockExt            = 0x25
+	PacketTypeBulkBlockUpdate           = 0x26
+	PacketTypeSetTextColor              = 0x27
+	PacketTypeSetMapEnvUrl              = 0x28
+	PacketTypeSetMapEnvProperty         = 0x29
+	PacketTypeSetEntityProperty         = 0x2a
+	PacketTypeTwoWayPing                = 0x2b
+	PacketTypeSetInventoryOrder         = 0x2c
+	PacketTypeSetHotbar                 = 0x2d
+	PacketTypeSetSpawnpoint             = 0x2e
+	PacketTypeVelocityControl           = 0x2f
+	PacketTypeDefineEffect              = 0x30
+	PacketTypeSpawnEffect               = 0x31
+	PacketTypeDefineModel               = 0x32
+	PacketTypeDefineModelPart           = 0x33
+	PacketTypeUndefineModel             = 0x34
+	PacketTypePluginMessage             = 0x35
+	PacketTypeExtEntityTeleport         = 0x36
+)
+
+// Extensions are the negotiated extensions that change packet layouts.
+type Extensions struct {
+	ExtPos      bool // ExtEntityPositions
+	ExtBlocks   bool // ExtendedBlocks
+	ExtTextures bool // ExtendedTextures
+	FastMap     bool
+}
+
+// PadString converts str to a 64-byte string padded with spaces.
+func PadString(str string) [64]byte {
+	var result [64]byte
+	copy(result[:], str)
+	if len(str) < 64 {
+		copy(result[len(str):], bytes.Repeat([]byte{' '}, 64-len(str)))
+	}
+
+	return result
+}
+
+// TrimString converts a 64-byte string to a Go string.
+func TrimString(str [64]byte) string {
+	return strings.TrimRight(string(str[:]), " ")
+}
+
+// Packet is a buffer of encoded packets.
+type Packet struct {
+	bytes.Buffer
+}
+
+// Marshal writes v in big-endian order.
+func (packet *Packet) Marshal(v interface{}) {
+	if err := binary.Write(packet, binary.BigEndian, v); err != nil {
+		log.Printf("packet: %s\n", err)
+	}
+}
+
+// Position writes an absolute position in fixed-point units.
+func (packet *Packet) Position(x, y, z float64, extPos bool) {
+	if extPos {
+		packet.Marshal(struct{ X, Y, Z int32 }{
+			int32(x * 32),
+			int32(y * 32),
+			int32(z * 32),
+		})
+	} else {
+		packet.Marshal(struct{ X, Y, Z int16 }{
+			int16(x * 32),
+			int16(y * 32),
+			int16(z * 32),
+		})
+	}
+}
+
+// BlockID writes a block ID.
+func (packet *Packet) BlockID(block uint16, extBlocks bool) {
+	if extBlocks {
+		packet.Marshal(block)
+	} else {
+		packet.WriteByte(byte(block))
+	}
+}
+
+// TextureID writes a texture ID.
+func (packet *Packet) TextureID(textureID int, extTex bool) {
+	if extTex {
+		packet.Marshal(int16(textureID))
+	} else {
+		packet.WriteByte(byte(textureID))
+	}
+}
+
+// Identification writes an Identification packet. The server sends its name,
+// MOTD and user type; the client sends its name, verification key and the
+// CPE magic byte.
+func (packet *Packet) Identification(name, str string, b byte) {
+	packet.Marshal(struct {
+		PacketID        byte
+		ProtocolVersion byte
+		Name            [64]byte
+		Str             [64]byte
+		Byte            byte
+	}{PacketTypeIdentification, 0x07, PadString(name), PadString(str), b})
+}
+
+// Message writes a Message packet. The server sends the message type as
+// playerID; with LongerMessages, the client sends a non-zero playerID for
+// partial messages.
+func (packet *Packet) Message(playerID byte, message string) {
+	packet.Marshal(struct {
+		PacketID byte
+		PlayerID byte
+		Message  [64]byte
+	}{PacketTypeMessage, playerID, PadString(message)})
+}
+
+func (packet *Packet) ExtInfo(appName string, count int) {
+	packet.Marshal(struct {
+		PacketID       byte
+		AppName        [64]byte
+		ExtensionCount int16
+	}{PacketTypeExtInfo, PadString(appName), int16(count)})
+}
+
+func (packet *Packet) ExtEntry(name string, version int) {
+	packet.Marshal(struct {
+		PacketID byte
+		ExtName  [64]byte
+		Version  int32
+	}{PacketTypeExtEntry, PadString(name), int32(version)})
+}
+
+func (packet *Packet) CustomBlockSupportLevel(level byte) {
+	packet.Marshal(struct {
+		PacketID     byte
+		SupportLevel byte
+	}{PacketTypeCustomBlockSupportLevel, level})
+}
+
+func (packet *Packet) TwoWayPing(dir byte, data int16) {
+	packet.Marshal(struct {
+		PacketID  byte
+		Direction byte
+		Data      int16
+	}{PacketTypeTwoWayPing, dir, data})
+}
+
+func (packet *Packet) PluginMessage(channel byte, data []byte) {
+	var payload [64]byte
+	copy(payload[:], data)
+	packet.Marshal(struct {
+		PacketID byte
+		Channel  byte
+		Data     [64]byte
+	}{PacketTypePluginMessage, channel, payload})
+}
+
+// ReadBlockID reads a block ID.
+func ReadBlockID(reader io.Reader, extBlocks bool) uint16 {
+	if extBlocks {
+		var block uint16
+		binary.Read(reader, binary.BigEndian, &block)
+		return block
+	}
+
+	var block byte
+	binary.Read(reader, binary.BigEndian, &block)
+	return uint16(block)
+}
+
+// ReadTextureID reads a texture ID.
+func ReadTextureID(reader io.Reader, extTex bool) int {
+	if extTex {
+		var texture uint16
+		binary.Read(reader, binary.BigEndian, &texture)
+		return int(texture)
+	}
+
+	var texture byte
+	binary.Read(reader, binary.BigEndian, &texture)
+	return int(texture)
+}
+
+// ReadPosition reads an absolute position in fixed-point units.
+func ReadPosition(reader io.Reader, extPos bool) (x, y, z float64) {
+	if extPos {
+		packet := struct{ X, Y, Z int32 }{}
+		binary.Read(reader, binary.BigEndian, &packet)
+		return float64(packet.X) / 32, float64(packet.Y) / 32, float64(packet.Z) / 32
+	}
+
+	packet := struct{ X, Y, Z int16 }{}
+	binary.Read(reader, binary.BigEndian, &packet)
+	return float64(packet.X) / 32, float64(packet.Y) / 32, float64(packet.Z) / 32
+}
+
+// ClientPacketSize returns the size of a packet sent by the client, or 0 if
+// the packet is unknown.
+func ClientPacketSize(id byte, ext Extensions) int {
+	pos := 6
+	if ext.ExtPos {
+		pos = 12
+	}
+
+	block := 1
+	if ext.ExtBlocks {
+		block = 2
+	}
+
+	switch id {
+	case PacketTypeIdentification:
+		return 131
+	case PacketTypeSetBlockClient:
+		return 8 + block
+	case PacketTypePlayerTeleport:
+		return 3 + block + pos
+	case PacketTypeMessage:
+		return 66
+	case PacketTypeExtInfo:
+		return 67
+	case PacketTypeExtEntry:
+		return 69
+	case PacketTypeCustomBlockSupportLevel:
+		return 2
+	case PacketTypePlayerClicked:
+		return 15
+	case PacketTypeTwoWayPing:
+		return 4
+	case PacketTypePluginMessage:
+		return 66
+	default:
+		return 0
+	}
+}
+
+// ServerPacketSize returns the size of a packet sent by the server, or 0 if
+// the packet is unknown.
+func ServerPacketSize(id byte, ext Extensions) int {
+	pos := 6
+	if ext.ExtPos {
+		pos = 12
+	}
+
+	block := 1
+	if ext.ExtBlocks {
+		block = 2
+	}
+
+	tex := 1
+	if ext.ExtTextures {
+		tex = 2
+	}
+
+	switch id {
+	case PacketTypeIdentification:
+		return 131
+	case PacketTypePing:
+		return 1
+	case PacketTypeLevelInitialize:
+		if ext.FastMap {
+			return 5
+		}
+		return 1
+	case PacketTypeLevelDataChunk:
+		return 1028
+	case PacketTypeLevelFinalize:
+		return 7
+	case PacketTypeSetBlock:
+		return 7 + block
+	case PacketTypeAddEntity:
+		return 68 + pos
+	case PacketTypePlayerTeleport:
+		return 4 + pos
+	case PacketTypePositionOrientationUpdate:
+		return 7
+	case PacketTypePositionUpdate:
+		return 5
+	case PacketTypeOrientationUpdate:
+		return 4
+	case PacketTypeRemoveEntity:
+		return 2
+	case PacketTypeMessage:
+		return 66
+	case PacketTypeKick:
+		return 65
+	case PacketTypeUpdateUserType:
+		return 2
+	case PacketTypeExtInfo:
+		return 67
+	case PacketTypeExtEntry:
+		return 69
+	case PacketTypeSetClickDistance:
+		return 3
+	case PacketTypeCustomBlockSupportLevel:
+		return 2
+	case PacketTypeHoldThis:
+		return 2 + block
+	case PacketTypeSetTextHotKey:
+		return 134
+	case PacketTypeExtAddPlayerName:
+		return 196
+	case PacketTypeExtRemovePlayerName:
+		return 3
+	case PacketTypeEnvSetColor:
+		return 8
+	case PacketTypeMakeSelection:
+		return 86
+	case PacketTypeRemoveSelection:
+		return 2
+	case PacketTypeSetBlockPermission:
+		return 3 + block
+	case PacketTypeChangeModel:
+		return 66
+	case PacketTypeEnvSetWeatherType:
+		return 2
+	case PacketTypeHackControl:
+		return 8
+	case PacketTypeExtAddEntity2:
+		return 132 + pos
+	case PacketTypeDefineBlock:
+		return 76 + block + 3*tex
+	case PacketTypeRemoveBlockDefinition:
+		return 1 + block
+	case PacketTypeDefineBlockExt:
+		return 81 + block + 6*tex
+	case PacketTypeBulkBlockUpdate:
+		if ext.ExtBlocks {
+			return 1346
+		}
+		return 1282
+	case PacketTypeSetTextColor:
+		return 6
+	case PacketTypeSetMapEnvUrl:
+		return 65
+	case PacketTypeSetMapEnvProperty:
+		return 6
+	case PacketTypeSetEntityProperty:
+		return 7
+	case PacketTypeTwoWayPing:
+		return 4
+	case PacketTypeSetInventoryOrder:
+		return 1 + 2*block
+	case PacketTypeSetHotbar:
+		return 2 + block
+	case PacketTypeSetSpawnpoint:
+		return 3 + pos
+	case PacketTypeVelocityControl:
+		return 16
+	case PacketTypeDefineEffect:
+		return 36
+	case PacketTypeSpawnEffect:
+		return 26
+	case PacketTypeDefineModel:
+		return 116
+	case PacketTypeDefineModelPart:
+		return 167
+	case PacketTypeUndefineModel:
+		return 2
+	case PacketTypePluginMessage:
+		return 66
+	case PacketTypeExtEntityTeleport:
+		return 5 + pos
+	default:
+		return 0
+	}
+}
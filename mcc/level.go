@@ -15,7 +15,7 @@ type LevelStorage interface {
 // Simulator is the interface that must be implemented by block-based physics
 // simulators.
 type Simulator interface {
-	Update(block, old byte, index int)
+	Update(block, old uint16, index int)
 	Tick()
 }
 
@@ -30,8 +30,8 @@ type EnvConfig struct {
 	Weather     byte
 	TexturePack string
 
-	SideBlock       byte
-	EdgeBlock       byte
+	SideBlock       uint16
+	EdgeBlock       uint16
 	EdgeHeight      int
 	CloudHeight     int
 	MaxViewDistance int
@@ -90,7 +90,7 @@ type Level struct {
 	Width  int
 	Height int
 	Length int
-	Blocks []byte
+	Blocks []uint16
 	Dirty  bool
 
 	Name        string
@@ -101,12 +101,27 @@ type Level struct {
 	EnvConfig   EnvConfig
 	HackConfig  HackConfig
 	BlockDefs   []*BlockDefinition
-	Inventory   []byte
+	Inventory   []uint16
+
+	// ViewDistance is the distance in blocks beyond which entities are
+	// hidden from players. If zero, EnvConfig.MaxViewDistance is used.
+	// A negative value disables culling.
+	ViewDistance int
 
 	Metadata, MetadataCPE map[string]interface{}
 
 	simulators     []Simulator
 	simulatorsLock sync.RWMutex
+
+	version   uint64
+	cache     map[levelCacheKey]*levelCacheEntry
+	cacheLock sync.Mutex
+
+	// entities and players are replaced instead of modified, so that they
+	// can be iterated without holding the lock.
+	entities     []*Entity
+	players      []*Player
+	entitiesLock sync.Mutex
 }
 
 // NewLevel creates a new empty Level with the specified name and dimensions.
@@ -119,7 +134,7 @@ func NewLevel(name string, width, height, length int) *Level {
 		Width:       width,
 		Height:      height,
 		Length:      length,
-		Blocks:      make([]byte, width*height*length),
+		Blocks:      make([]uint16, width*height*length),
 		Dirty:       true,
 		Name:        name,
 		UUID:        RandomUUID(),
@@ -151,20 +166,21 @@ func (level *Level) Clone(name string) *Level {
 	}
 
 	newLevel := &Level{
-		Width:       level.Width,
-		Height:      level.Height,
-		Length:      level.Length,
-		Blocks:      make([]byte, len(level.Blocks)),
-		Dirty:       true,
-		Name:        name,
-		UUID:        RandomUUID(),
-		TimeCreated: time.Now(),
-		MOTD:        level.MOTD,
-		Spawn:       level.Spawn,
-		EnvConfig:   level.EnvConfig,
-		HackConfig:  level.HackConfig,
-		Metadata:    level.Metadata,
-		MetadataCPE: level.MetadataCPE,
+		Width:        level.Width,
+		Height:       level.Height,
+		Length:       level.Length,
+		Blocks:       make([]uint16, len(level.Blocks)),
+		Dirty:        true,
+		Name:         name,
+		UUID:         RandomUUID(),
+		TimeCreated:  time.Now(),
+		MOTD:         level.MOTD,
+		Spawn:        level.Spawn,
+		EnvConfig:    level.EnvConfig,
+		HackConfig:   level.HackConfig,
+		Metadata:     level.Metadata,
+		ViewDistance: level.ViewDistance,
+		MetadataCPE:  level.MetadataCPE,
 	}
 
 	copy(newLevel.Blocks, level.Blocks)
@@ -173,7 +189,7 @@ func (level *Level) Clone(name string) *Level {
 		copy(newLevel.BlockDefs, level.BlockDefs)
 	}
 	if level.Inventory != nil {
-		newLevel.Inventory = make([]byte, len(level.Inventory))
+		newLevel.Inventory = make([]uint16, len(level.Inventory))
 		copy(newLevel.Inventory, level.Inventory)
 	}
 
@@ -226,7 +242,7 @@ func (level *Level) InBounds(x, y, z int) bool {
 }
 
 // GetBlock returns the block at the specified coordinates.
-func (level *Level) GetBlock(x, y, z int) byte {
+func (level *Level) GetBlock(x, y, z int) uint16 {
 	if x < level.Width && y < level.Height && z < level.Length {
 		return level.Blocks[level.Index(x, y, z)]
 	}
@@ -236,10 +252,10 @@ func (level *Level) GetBlock(x, y, z int) byte {
 
 // SetBlockFast sets the block at the specified coordinates without notifying
 // the physics simulators.
-func (level *Level) SetBlockFast(x, y, z int, block byte) {
+func (level *Level) SetBlockFast(x, y, z int, block uint16) {
 	if level.InBounds(x, y, z) {
-		level.Dirty = true
 		level.Blocks[level.Index(x, y, z)] = block
+		level.changed()
 		level.ForEachPlayer(func(player *Player) {
 			player.sendBlockChange(x, y, z, block)
 		})
@@ -247,13 +263,13 @@ func (level *Level) SetBlockFast(x, y, z int, block byte) {
 }
 
 // SetBlock sets the block at the specified coordinates.
-func (level *Level) SetBlock(x, y, z int, block byte) {
+func (level *Level) SetBlock(x, y, z int, block uint16) {
 	if level.InBounds(x, y, z) {
 		index := level.Index(x, y, z)
 		old := level.Blocks[index]
 
-		level.Dirty = true
 		level.Blocks[index] = block
+		level.changed()
 		level.ForEachPlayer(func(player *Player) {
 			player.sendBlockChange(x, y, z, block)
 		})
@@ -285,34 +301,121 @@ func (level *Level) SetBlock(x, y, z int, block byte) {
 	}
 }
 
+// BlockDef returns the definition of the specified custom block, or nil if
+// the block is not defined in the level.
+func (level *Level) BlockDef(block uint16) *BlockDefinition {
+	if int(block) < len(level.BlockDefs) {
+		return level.BlockDefs[block]
+	}
+
+	return nil
+}
+
+// CollideMode returns the collision mode of the specified block.
+func (level *Level) CollideMode(block uint16) byte {
+	if def := level.BlockDef(block); def != nil {
+		return def.CollideMode
+	}
+
+	switch block {
+	case BlockAir, BlockSapling, BlockDandelion, BlockRose,
+		BlockBrownShroom, BlockRedShroom, BlockRope, BlockSnow, BlockFire:
+		return CollideModeWalk
+	case BlockActiveWater, BlockWater, BlockActiveLava, BlockLava:
+		return CollideModeSwim
+	default:
+		return CollideModeSolid
+	}
+}
+
 // FillLayers fills the specified range of layers with block.
-func (level *Level) FillLayers(yStart, yEnd int, block byte) {
+func (level *Level) FillLayers(yStart, yEnd int, block uint16) {
 	start := yStart * level.Width * level.Length
 	end := (yEnd + 1) * level.Width * level.Length
 	for i := start; i < end; i++ {
 		level.Blocks[i] = block
 	}
+	level.changed()
+}
+
+// viewDistance returns the distance beyond which entities are hidden, or 0
+// if all entities are visible.
+func (level *Level) viewDistance() float64 {
+	distance := level.ViewDistance
+	if distance == 0 {
+		distance = level.EnvConfig.MaxViewDistance
+	}
+
+	if distance < 0 {
+		return 0
+	}
+
+	return float64(distance)
 }
 
 // ForEachEntity calls fn for each entity in the level.
 func (level *Level) ForEachEntity(fn func(*Entity)) {
-	if level.server != nil {
-		level.server.ForEachEntity(func(entity *Entity) {
-			if entity.level == level {
-				fn(entity)
-			}
-		})
+	level.entitiesLock.Lock()
+	entities := level.entities
+	level.entitiesLock.Unlock()
+
+	for _, entity := range entities {
+		fn(entity)
 	}
 }
 
 // ForEachPlayer calls fn for each player in the level.
 func (level *Level) ForEachPlayer(fn func(*Player)) {
-	if level.server != nil {
-		level.server.ForEachPlayer(func(player *Player) {
-			if player.level == level {
-				fn(player)
+	level.entitiesLock.Lock()
+	players := level.players
+	level.entitiesLock.Unlock()
+
+	for _, player := range players {
+		fn(player)
+	}
+}
+
+func (level *Level) addEntity(entity *Entity) {
+	level.entitiesLock.Lock()
+	defer level.entitiesLock.Unlock()
+
+	for _, e := range level.entities {
+		if e == entity {
+			return
+		}
+	}
+
+	entities := make([]*Entity, len(level.entities), len(level.entities)+1)
+	copy(entities, level.entities)
+	level.entities = append(entities, entity)
+
+	if entity.player != nil {
+		players := make([]*Player, len(level.players), len(level.players)+1)
+		copy(players, level.players)
+		level.players = append(players, entity.player)
+	}
+}
+
+func (level *Level) removeEntity(entity *Entity) {
+	level.entitiesLock.Lock()
+	defer level.entitiesLock.Unlock()
+
+	entities := make([]*Entity, 0, len(level.entities))
+	for _, e := range level.entities {
+		if e != entity {
+			entities = append(entities, e)
+		}
+	}
+	level.entities = entities
+
+	if entity.player != nil {
+		players := make([]*Player, 0, len(level.players))
+		for _, player := range level.players {
+			if player != entity.player {
+				players = append(players, player)
 			}
-		})
+		}
+		level.players = players
 	}
 }
 
@@ -337,10 +440,10 @@ func (level *Level) SendMOTD() {
 		if player.cpe[CpeInstantMOTD] {
 			player.sendMOTD(level)
 		} else {
-			player.level = nil
+			player.setLevel(nil)
 			player.despawnLevel(level)
 			player.spawnLevel(level)
-			player.level = level
+			player.setLevel(level)
 		}
 	})
 }
@@ -402,7 +505,7 @@ type BlockBuffer struct {
 	level   *Level
 	count   int
 	indices [256]int32
-	blocks  [256]byte
+	blocks  [256]uint16
 }
 
 // NewBlockBuffer returns a new BlockBuffer to queue changes to level.
@@ -411,7 +514,7 @@ func NewBlockBuffer(level *Level) *BlockBuffer {
 }
 
 // Set sets the block at the specified coordinates.
-func (buffer *BlockBuffer) Set(x, y, z int, block byte) {
+func (buffer *BlockBuffer) Set(x, y, z int, block uint16) {
 	buffer.indices[buffer.count] = int32(buffer.level.Index(x, y, z))
 	buffer.blocks[buffer.count] = block
 	buffer.count++
@@ -427,21 +530,21 @@ func (buffer *BlockBuffer) Flush() {
 		buffer.level.Blocks[index] = buffer.blocks[i]
 	}
 
-	buffer.level.Dirty = true
+	buffer.level.changed()
 	buffer.level.ForEachPlayer(func(player *Player) {
-		var blocks [256]byte
-		for i := 0; i < buffer.count; i++ {
-			blocks[i] = player.convertBlock(buffer.blocks[i], buffer.level)
-		}
-
-		var packet packet
 		if player.cpe[CpeBulkBlockUpdate] {
-			packet.bulkBlockUpdate(buffer.indices[:], blocks[:buffer.count])
-		} else {
 			for i := 0; i < buffer.count; i++ {
-				x, y, z := buffer.level.Position(int(buffer.indices[i]))
-				packet.setBlock(x, y, z, blocks[i])
+				block := player.convertBlock(buffer.blocks[i], buffer.level)
+				player.queueBlockChange(buffer.level, buffer.indices[i], block)
 			}
+			return
+		}
+
+		var packet packet
+		extBlocks := player.cpe[CpeExtendedBlocks]
+		for i := 0; i < buffer.count; i++ {
+			x, y, z := buffer.level.Position(int(buffer.indices[i]))
+			packet.setBlock(x, y, z, player.convertBlock(buffer.blocks[i], buffer.level), extBlocks)
 		}
 
 		player.sendPacket(packet)
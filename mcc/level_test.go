@@ -0,0 +1,147 @@
+package mcc_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+	"github.com/AndreasGoulas/go-mcc/mcc/mcctest"
+)
+
+func TestLevelVersion(t *testing.T) {
+	level := mcc.NewLevel("test", 16, 16, 16)
+	version := level.Version()
+
+	level.SetBlockFast(1, 1, 1, mcc.BlockStone)
+	if level.Version() == version {
+		t.Fatalf("SetBlockFast did not change the version")
+	}
+
+	version = level.Version()
+	buffer := mcc.NewBlockBuffer(level)
+	buffer.Set(2, 2, 2, mcc.BlockStone)
+	buffer.Flush()
+	if level.Version() == version {
+		t.Fatalf("BlockBuffer.Flush did not change the version")
+	}
+}
+
+func TestLevelCache(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	level := harness.Server.MainLevel
+	check := func(player *mcctest.Player) {
+		t.Helper()
+		blocks := player.Level().Blocks
+		for i, block := range level.Blocks {
+			if blocks[i] != block {
+				t.Fatalf("block %d = %d, want %d", i, blocks[i], block)
+			}
+		}
+	}
+
+	check(harness.Connect("alice", nil))
+	check(harness.Connect("bob", mcctest.NoExtensions))
+
+	level.SetBlock(3, 3, 3, mcc.BlockGold)
+	check(harness.Connect("carol", nil))
+	check(harness.Connect("dave", mcctest.NoExtensions))
+}
+
+func TestLevelCacheExtended(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	level := harness.Server.MainLevel
+	level.BlockDefs = make([]*mcc.BlockDefinition, 301)
+	level.BlockDefs[300] = &mcc.BlockDefinition{Name: "Marble", Fallback: mcc.BlockStone}
+	level.SetBlock(3, 3, 3, 300)
+	level.SetBlock(4, 4, 4, mcc.BlockGold)
+
+	var noFastMap []mcc.ExtEntry
+	for id, entry := range mcc.Extensions {
+		if id != mcc.CpeFastMap {
+			noFastMap = append(noFastMap, entry)
+		}
+	}
+
+	// Both block arrays are decoded with and without FastMap, and clients
+	// without ExtendedBlocks receive the fallback.
+	tests := []struct {
+		name       string
+		extensions []mcc.ExtEntry
+		block      uint16
+	}{
+		{"alice", nil, 300},
+		{"bob", noFastMap, 300},
+		{"carol", mcctest.NoExtensions, mcc.BlockStone},
+	}
+	for _, test := range tests {
+		player := harness.Connect(test.name, test.extensions)
+		blocks := player.Level()
+		if block := blocks.GetBlock(3, 3, 3); block != test.block {
+			t.Errorf("%s: GetBlock(3, 3, 3) = %d, want %d", test.name, block, test.block)
+		}
+		if block := blocks.GetBlock(4, 4, 4); block != mcc.BlockGold {
+			t.Errorf("%s: GetBlock(4, 4, 4) = %d, want %d", test.name, block, mcc.BlockGold)
+		}
+	}
+}
+
+func TestLevelMembers(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	server := harness.Server
+	other := mcc.NewLevel("other", 32, 16, 32)
+	server.AddLevel(other)
+
+	// RemoveLevel moves the players out of the level after it has released
+	// the level list lock, so handlers can look up levels during the move.
+	main := server.MainLevel
+	var lookups []*mcc.Level
+	var lookupsLock sync.Mutex
+	server.AddHandler(mcc.EventTypeEntityLevelChange, func(eventType int, event interface{}) {
+		level := server.FindLevel("main")
+		lookupsLock.Lock()
+		lookups = append(lookups, level)
+		lookupsLock.Unlock()
+	})
+
+	alice := harness.Connect("alice", nil)
+	player := server.FindPlayer("alice")
+	player.TeleportLevel(other)
+	alice.WaitUntil(func() bool { return alice.Level().Width == 32 })
+
+	var players []*mcc.Player
+	other.ForEachPlayer(func(player *mcc.Player) {
+		players = append(players, player)
+	})
+	if len(players) != 1 || players[0] != player {
+		t.Fatalf("players = %v, want [alice]", players)
+	}
+
+	server.MainLevel.ForEachEntity(func(entity *mcc.Entity) {
+		t.Fatalf("unexpected entity %s in main level", entity.Name())
+	})
+
+	lookupsLock.Lock()
+	lookups = nil
+	lookupsLock.Unlock()
+
+	server.RemoveLevel(other)
+	if player.Level() != server.MainLevel {
+		t.Fatalf("player was not moved to the main level")
+	}
+
+	lookupsLock.Lock()
+	if len(lookups) != 1 || lookups[0] != main {
+		t.Errorf("lookups during RemoveLevel = %v, want [main]", lookups)
+	}
+	lookupsLock.Unlock()
+
+	other.ForEachEntity(func(entity *mcc.Entity) {
+		t.Fatalf("unexpected entity %s in removed level", entity.Name())
+	})
+}
@@ -0,0 +1,131 @@
+package mcc
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+)
+
+// levelCacheKey identifies the compressed block data that is sent to players
+// with the same block conversion table and level format.
+type levelCacheKey struct {
+	conv      [BlockCount]uint16
+	fastMap   bool
+	extBlocks bool
+}
+
+type levelCacheEntry struct {
+	version uint64
+	chunks  [][]byte
+	ready   chan struct{}
+}
+
+// Version returns a counter that is incremented whenever blocks of the level
+// are changed through SetBlock, SetBlockFast, FillLayers or a BlockBuffer.
+func (level *Level) Version() uint64 {
+	return atomic.LoadUint64(&level.version)
+}
+
+func (level *Level) changed() {
+	level.Dirty = true
+	atomic.AddUint64(&level.version, 1)
+}
+
+// levelData returns the LevelDataChunk packets of the level for the
+// specified key. The data is compressed once per version of the level, and
+// concurrent callers wait for the same result.
+func (level *Level) levelData(key *levelCacheKey) [][]byte {
+	version := level.Version()
+
+	level.cacheLock.Lock()
+	if level.cache == nil {
+		level.cache = make(map[levelCacheKey]*levelCacheEntry)
+	}
+
+	entry := level.cache[*key]
+	if entry != nil && entry.version == version {
+		level.cacheLock.Unlock()
+		<-entry.ready
+		return entry.chunks
+	}
+
+	entry = &levelCacheEntry{version: version, ready: make(chan struct{})}
+	level.cache[*key] = entry
+	level.cacheLock.Unlock()
+
+	entry.chunks = level.compress(key)
+	close(entry.ready)
+	return entry.chunks
+}
+
+func (level *Level) compress(key *levelCacheKey) [][]byte {
+	stream := levelStream{}
+	stream.reset()
+	level.compressBlocks(&stream, key, 0)
+
+	extBlocks := false
+	for _, block := range key.conv {
+		if block > BlockMaxDefinitions {
+			extBlocks = true
+			break
+		}
+	}
+
+	// The upper bits of extended block IDs are sent as a second stream with
+	// the same framing, which the client recognizes by a non-zero chunk
+	// value.
+	if extBlocks && key.extBlocks {
+		stream.Close()
+		level.compressBlocks(&stream, key, 8)
+	}
+
+	stream.Close()
+	return stream.chunks
+}
+
+// compressBlocks writes the bits of the converted blocks selected by shift to
+// stream, as a deflate stream for clients that support FastMap and as a gzip
+// stream prefixed with the level size otherwise.
+func (level *Level) compressBlocks(stream *levelStream, key *levelCacheKey, shift uint) {
+	if key.fastMap {
+		writer, _ := flate.NewWriter(stream, -1)
+		level.writeBlocks(writer, stream, key, shift)
+		writer.Close()
+	} else {
+		writer := gzip.NewWriter(stream)
+		binary.Write(writer, binary.BigEndian, int32(level.Size()))
+		level.writeBlocks(writer, stream, key, shift)
+		writer.Close()
+	}
+}
+
+// writeBlocks writes either the lower (shift 0) or the upper (shift 8) bits of
+// the converted blocks of the level.
+func (level *Level) writeBlocks(writer io.Writer, stream *levelStream, key *levelCacheKey, shift uint) {
+	var buffer [1024]byte
+	for offset := 0; offset < len(level.Blocks); offset += len(buffer) {
+		blocks := level.Blocks[offset:]
+		if len(blocks) > len(buffer) {
+			blocks = blocks[:len(buffer)]
+		}
+
+		for i, block := range blocks {
+			buffer[i] = byte(key.conv[block] >> shift)
+		}
+
+		// Clients that support ExtendedBlocks interpret any non-zero
+		// chunk value as data of the second block array.
+		switch {
+		case !key.extBlocks:
+			stream.percent = byte(offset * 100 / len(level.Blocks))
+		case shift == 0:
+			stream.percent = 0
+		default:
+			stream.percent = 1
+		}
+
+		writer.Write(buffer[:len(blocks)])
+	}
+}
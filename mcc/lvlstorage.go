@@ -66,10 +66,15 @@ func (storage *LvlStorage) Load(name string) (level *Level, err error) {
 	level.Spawn.Z = float64(header.SpawnZ) + 0.5
 	level.Spawn.Yaw = float64(header.SpawnYaw) * 360 / 256
 	level.Spawn.Pitch = float64(header.SpawnPitch) * 360 / 256
-	if _, err = io.ReadFull(reader, level.Blocks); err != nil {
+	blocks := make([]byte, level.Size())
+	if _, err = io.ReadFull(reader, blocks); err != nil {
 		return nil, err
 	}
 
+	for i, block := range blocks {
+		level.Blocks[i] = uint16(block)
+	}
+
 	return
 }
 
@@ -99,6 +104,19 @@ func (storage *LvlStorage) Save(level *Level) (err error) {
 		return
 	}
 
-	_, err = writer.Write(level.Blocks)
+	// The format only stores 8-bit block IDs, so extended blocks are
+	// replaced with their fallback.
+	blocks := make([]byte, len(level.Blocks))
+	for i, block := range level.Blocks {
+		if block > BlockMaxDefinitions {
+			block = BlockAir
+			if def := level.BlockDef(level.Blocks[i]); def != nil && def.Fallback <= BlockMaxDefinitions {
+				block = def.Fallback
+			}
+		}
+		blocks[i] = byte(block)
+	}
+
+	_, err = writer.Write(blocks)
 	return
 }
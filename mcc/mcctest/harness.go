@@ -0,0 +1,241 @@
+// Package mcctest provides an in-process harness for testing the server and
+// plugins with fake players.
+package mcctest
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+	"github.com/AndreasGoulas/go-mcc/mcc/client"
+	"github.com/AndreasGoulas/go-mcc/mcc/internal/proto"
+	"github.com/AndreasGoulas/go-mcc/mcc/memnet"
+)
+
+// Timeout is the time that the Expect methods wait for a packet.
+var Timeout = 5 * time.Second
+
+// NoExtensions is the extension set of a player that does not support CPE.
+var NoExtensions = []mcc.ExtEntry{}
+
+// Extensions returns the extension set with the specified CPE extensions.
+func Extensions(ids ...int) []mcc.ExtEntry {
+	extensions := []mcc.ExtEntry{}
+	for _, id := range ids {
+		extensions = append(extensions, mcc.Extensions[id])
+	}
+
+	return extensions
+}
+
+// Harness runs a server that accepts connections from fake players.
+type Harness struct {
+	t        testing.TB
+	Server   *mcc.Server
+	Listener *memnet.Listener
+	Storage  *mcc.MemoryStorage
+
+	cancel  context.CancelFunc
+	done    chan error
+	players []*Player
+}
+
+// NewHarness starts a server with the specified configuration. If config is
+// nil, a default configuration is used.
+func NewHarness(t testing.TB, config *mcc.Config) *Harness {
+	t.Helper()
+	if config == nil {
+		config = &mcc.Config{
+			Name:       "Test",
+			MOTD:       "Test server",
+			MaxPlayers: 16,
+			MainLevel:  "main",
+		}
+	}
+
+	harness := &Harness{
+		t:        t,
+		Listener: memnet.NewListener(),
+		Storage:  mcc.NewMemoryStorage(),
+		done:     make(chan error, 1),
+	}
+
+	harness.Server = mcc.NewServer(config, harness.Storage)
+	if harness.Server == nil {
+		t.Fatalf("mcctest: failed to create server")
+	}
+
+	var ctx context.Context
+	ctx, harness.cancel = context.WithCancel(context.Background())
+	go func() {
+		harness.done <- harness.Server.Serve(ctx, harness.Listener)
+	}()
+
+	return harness
+}
+
+// Close disconnects all players and stops the server.
+func (harness *Harness) Close() {
+	for _, player := range harness.players {
+		player.Close()
+	}
+
+	harness.cancel()
+	if err := <-harness.done; err != nil {
+		harness.t.Errorf("mcctest: %s", err)
+	}
+}
+
+// Connect logs in a fake player with the specified name and extension set,
+// and waits until the player has spawned in the main level. If extensions
+// is nil, all extensions are supported.
+func (harness *Harness) Connect(name string, extensions []mcc.ExtEntry) *Player {
+	harness.t.Helper()
+	conn, err := harness.Listener.Dial()
+	if err != nil {
+		harness.t.Fatalf("mcctest: %s", err)
+	}
+
+	player := &Player{
+		t: harness.t,
+		Client: client.NewClient(conn, client.Config{
+			Name:       name,
+			Extensions: extensions,
+		}),
+		notify: make(chan struct{}, 1),
+	}
+
+	player.AddHandler(client.EventTypePacket, player.handlePacket)
+	if err := player.Login(); err != nil {
+		conn.Close()
+		harness.t.Fatalf("mcctest: %s", err)
+	}
+
+	go player.Run()
+	harness.players = append(harness.players, player)
+
+	player.WaitUntil(func() bool {
+		return player.Level() != nil && player.Entity(client.SelfID) != nil
+	})
+
+	return player
+}
+
+// Player is a fake player connected to a harness.
+type Player struct {
+	*client.Client
+	t testing.TB
+
+	packets     []client.EventPacket
+	cursor      int
+	packetsLock sync.Mutex
+	notify      chan struct{}
+}
+
+func (player *Player) handlePacket(eventType int, event interface{}) {
+	e := event.(*client.EventPacket)
+	player.packetsLock.Lock()
+	player.packets = append(player.packets, *e)
+	player.packetsLock.Unlock()
+
+	select {
+	case player.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Packets returns the data of all received packets with the specified ID.
+func (player *Player) Packets(id byte) (packets [][]byte) {
+	player.packetsLock.Lock()
+	defer player.packetsLock.Unlock()
+	for _, packet := range player.packets {
+		if packet.ID == id {
+			packets = append(packets, packet.Data)
+		}
+	}
+
+	return
+}
+
+// Skip discards the packets that have been received so far, so that the
+// Expect methods only match packets that are received later.
+func (player *Player) Skip() {
+	player.packetsLock.Lock()
+	player.cursor = len(player.packets)
+	player.packetsLock.Unlock()
+}
+
+// Expect waits for a packet with the specified ID for which fn returns true,
+// and returns its data. fn may be nil. Packets up to the matching one are
+// consumed. The test fails if no packet matches before Timeout.
+func (player *Player) Expect(id byte, fn func(data []byte) bool) []byte {
+	player.t.Helper()
+	deadline := time.After(Timeout)
+	for {
+		player.packetsLock.Lock()
+		for i := player.cursor; i < len(player.packets); i++ {
+			packet := player.packets[i]
+			if packet.ID == id && (fn == nil || fn(packet.Data)) {
+				player.cursor = i + 1
+				player.packetsLock.Unlock()
+				return packet.Data
+			}
+		}
+		player.packetsLock.Unlock()
+
+		select {
+		case <-player.notify:
+		case <-deadline:
+			player.t.Fatalf("mcctest: timed out waiting for packet 0x%02x", id)
+			return nil
+		}
+	}
+}
+
+// ExpectMessage waits for a chat message that contains substr and returns
+// the message.
+func (player *Player) ExpectMessage(substr string) string {
+	player.t.Helper()
+	data := player.Expect(proto.PacketTypeMessage, func(data []byte) bool {
+		return strings.Contains(messageText(data), substr)
+	})
+
+	return messageText(data)
+}
+
+// ExpectNone fails the test if a packet with the specified ID is received
+// within d.
+func (player *Player) ExpectNone(id byte, d time.Duration) {
+	player.t.Helper()
+	time.Sleep(d)
+	player.packetsLock.Lock()
+	defer player.packetsLock.Unlock()
+	for _, packet := range player.packets[player.cursor:] {
+		if packet.ID == id {
+			player.t.Fatalf("mcctest: unexpected packet 0x%02x", id)
+		}
+	}
+}
+
+// WaitUntil polls fn until it returns true. The test fails if it does not
+// return true before Timeout.
+func (player *Player) WaitUntil(fn func() bool) {
+	player.t.Helper()
+	deadline := time.Now().Add(Timeout)
+	for !fn() {
+		if time.Now().After(deadline) {
+			player.t.Fatalf("mcctest: timed out waiting for condition")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func messageText(data []byte) string {
+	var message [64]byte
+	copy(message[:], data[2:])
+	return proto.TrimString(message)
+}
@@ -0,0 +1,65 @@
+// Package memnet provides a net.Listener whose connections stay in memory.
+package memnet
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// Listener is a net.Listener whose connections are created with net.Pipe.
+type Listener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener returns a new Listener.
+func NewListener() *Listener {
+	return &Listener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Dial creates a connection to the listener and returns the client side.
+func (listener *Listener) Dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case listener.conns <- server:
+		return client, nil
+	case <-listener.done:
+		client.Close()
+		server.Close()
+		return nil, errors.New("memnet: listener closed")
+	}
+}
+
+// Accept implements net.Listener.
+func (listener *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.done:
+		return nil, errors.New("memnet: listener closed")
+	}
+}
+
+// Close implements net.Listener.
+func (listener *Listener) Close() error {
+	listener.closeOnce.Do(func() {
+		close(listener.done)
+	})
+
+	return nil
+}
+
+// Addr implements net.Listener.
+func (listener *Listener) Addr() net.Addr {
+	return pipeAddr{}
+}
@@ -0,0 +1,39 @@
+package mcc
+
+import (
+	"errors"
+	"sync"
+)
+
+// MemoryStorage is a LevelStorage that keeps copies of the levels in
+// memory.
+type MemoryStorage struct {
+	levels     map[string]*Level
+	levelsLock sync.Mutex
+}
+
+// NewMemoryStorage returns a new empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{levels: make(map[string]*Level)}
+}
+
+// Load implements LevelStorage.
+func (storage *MemoryStorage) Load(name string) (*Level, error) {
+	storage.levelsLock.Lock()
+	defer storage.levelsLock.Unlock()
+
+	level := storage.levels[name]
+	if level == nil {
+		return nil, errors.New("memstorage: level not found")
+	}
+
+	return level.Clone(name), nil
+}
+
+// Save implements LevelStorage.
+func (storage *MemoryStorage) Save(level *Level) error {
+	storage.levelsLock.Lock()
+	defer storage.levelsLock.Unlock()
+	storage.levels[level.Name] = level.Clone(level.Name)
+	return nil
+}
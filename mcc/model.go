@@ -0,0 +1,264 @@
+package mcc
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// MaxModels is the maximum number of custom models that can be
+	// registered.
+	MaxModels = 64
+
+	// MaxModelParts is the maximum number of parts of a custom model.
+	MaxModelParts = 64
+
+	// MaxModelAnims is the maximum number of animations of a model part.
+	MaxModelAnims = 4
+)
+
+const (
+	ModelAnimNone = iota
+	ModelAnimHead
+	ModelAnimLeftLegX
+	ModelAnimRightLegX
+	ModelAnimLeftArmX
+	ModelAnimLeftArmZ
+	ModelAnimRightArmX
+	ModelAnimRightArmZ
+	ModelAnimSpin
+	ModelAnimSpinVelocity
+	ModelAnimSinRotate
+	ModelAnimSinRotateVelocity
+	ModelAnimSinTranslate
+	ModelAnimSinTranslateVelocity
+	ModelAnimSinSize
+	ModelAnimSinSizeVelocity
+)
+
+const (
+	ModelAxisX = 0
+	ModelAxisY = 1
+	ModelAxisZ = 2
+)
+
+// ModelUV describes the texture coordinates of a face of a model part.
+type ModelUV struct {
+	U1 uint16 `json:"u1"`
+	V1 uint16 `json:"v1"`
+	U2 uint16 `json:"u2"`
+	V2 uint16 `json:"v2"`
+}
+
+// ModelUVs holds the texture coordinates of the six faces of a model part.
+type ModelUVs struct {
+	Top    ModelUV `json:"top"`
+	Bottom ModelUV `json:"bottom"`
+	Front  ModelUV `json:"front"`
+	Back   ModelUV `json:"back"`
+	Left   ModelUV `json:"left"`
+	Right  ModelUV `json:"right"`
+}
+
+// ModelAnim describes an animation of a model part.
+// The meaning of the parameters A, B, C and D depends on the type.
+type ModelAnim struct {
+	Type byte    `json:"type"`
+	Axis byte    `json:"axis"`
+	A    float64 `json:"a"`
+	B    float64 `json:"b"`
+	C    float64 `json:"c"`
+	D    float64 `json:"d"`
+}
+
+// ModelPart represents a box of a custom model.
+type ModelPart struct {
+	Min            Vector3F    `json:"min"`
+	Max            Vector3F    `json:"max"`
+	UV             ModelUVs    `json:"uv"`
+	RotationOrigin Vector3F    `json:"rotation-origin"`
+	Rotation       Vector3F    `json:"rotation"`
+	Anims          []ModelAnim `json:"anims"`
+	FullBright     bool        `json:"full-bright"`
+	FirstPersonArm bool        `json:"first-person-arm"`
+}
+
+// Model represents a custom entity model.
+type Model struct {
+	id byte
+
+	Name string `json:"name"`
+
+	// Fallback is the name of the builtin model that is shown to clients
+	// that do not support custom models.
+	Fallback string `json:"fallback"`
+
+	NameY          float64     `json:"name-y"`
+	EyeY           float64     `json:"eye-y"`
+	Collision      Vector3F    `json:"collision"`
+	PickingMin     Vector3F    `json:"picking-min"`
+	PickingMax     Vector3F    `json:"picking-max"`
+	UScale         uint16      `json:"u-scale"`
+	VScale         uint16      `json:"v-scale"`
+	Bobbing        bool        `json:"bobbing"`
+	Pushes         bool        `json:"pushes"`
+	UsesHumanSkin  bool        `json:"uses-human-skin"`
+	CalcHumanAnims bool        `json:"calc-human-anims"`
+	Parts          []ModelPart `json:"parts"`
+}
+
+// LoadModel reads a model definition from the JSON file at path.
+// If the definition does not specify a name, the name of the file without
+// its extension is used.
+func LoadModel(path string) (*Model, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	model := &Model{
+		Fallback:       ModelHumanoid,
+		UScale:         64,
+		VScale:         64,
+		Bobbing:        true,
+		Pushes:         true,
+		UsesHumanSkin:  true,
+		CalcHumanAnims: true,
+	}
+	if err := json.Unmarshal(data, model); err != nil {
+		return nil, err
+	}
+
+	if len(model.Name) == 0 {
+		base := filepath.Base(path)
+		model.Name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+
+	return model, nil
+}
+
+func (model *Model) validate() error {
+	switch {
+	case len(model.Name) == 0 || len(model.Name) > 64:
+		return errors.New("model: invalid name")
+	case len(model.Parts) > MaxModelParts:
+		return errors.New("model: too many parts")
+	}
+
+	for _, part := range model.Parts {
+		if len(part.Anims) > MaxModelAnims {
+			return errors.New("model: too many animations")
+		}
+	}
+
+	return nil
+}
+
+// AddModel registers model with the server and sends it to all players.
+// A model with the same name is replaced.
+func (server *Server) AddModel(model *Model) error {
+	if err := model.validate(); err != nil {
+		return err
+	}
+
+	model.Name = strings.ToLower(model.Name)
+
+	server.modelsLock.Lock()
+	index := -1
+	for i, m := range server.models {
+		if m != nil && m.Name == model.Name {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		for i, m := range server.models {
+			if m == nil {
+				index = i
+				break
+			}
+		}
+	}
+
+	if index == -1 {
+		server.modelsLock.Unlock()
+		return errors.New("model: too many models")
+	}
+
+	model.id = byte(index)
+	server.models[index] = model
+	server.modelsLock.Unlock()
+
+	server.ForEachPlayer(func(player *Player) {
+		player.sendModel(model)
+	})
+	server.refreshModel(model.Name)
+	return nil
+}
+
+// RemoveModel removes the model with the specified name from the server.
+func (server *Server) RemoveModel(name string) {
+	name = strings.ToLower(name)
+
+	server.modelsLock.Lock()
+	var model *Model
+	for i, m := range server.models {
+		if m != nil && m.Name == name {
+			model = m
+			server.models[i] = nil
+			break
+		}
+	}
+	server.modelsLock.Unlock()
+
+	if model == nil {
+		return
+	}
+
+	server.ForEachPlayer(func(player *Player) {
+		player.sendUndefineModel(model)
+	})
+	server.refreshModel(name)
+}
+
+// FindModel returns the custom model with the specified name.
+func (server *Server) FindModel(name string) *Model {
+	name = strings.ToLower(name)
+
+	server.modelsLock.RLock()
+	defer server.modelsLock.RUnlock()
+
+	for _, model := range server.models {
+		if model != nil && model.Name == name {
+			return model
+		}
+	}
+
+	return nil
+}
+
+// ForEachModel calls fn for each custom model.
+func (server *Server) ForEachModel(fn func(*Model)) {
+	server.modelsLock.RLock()
+	defer server.modelsLock.RUnlock()
+
+	for _, model := range server.models {
+		if model != nil {
+			fn(model)
+		}
+	}
+}
+
+// refreshModel resends the model of all entities that use the model with
+// the specified name, so that clients pick up its new definition.
+func (server *Server) refreshModel(name string) {
+	server.ForEachEntity(func(entity *Entity) {
+		if strings.ToLower(entity.Model) == name {
+			entity.SendModel()
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package mcc
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MovementViolationSpeed = iota
+	MovementViolationVertical
+	MovementViolationNoClip
+)
+
+const (
+	// playerEyeHeight is the distance between the feet of a player and the
+	// position reported by the client.
+	playerEyeHeight = 51.0 / 32
+	playerHeight    = 1.75
+
+	defaultJumpHeight = 1.233
+	jumpSlack         = 0.5
+
+	maxWalkSpeed  = 6.0
+	maxWalkBurst  = 3.0
+	movementGrace = time.Second
+	velocityGrace = 3 * time.Second
+)
+
+// movementChecker holds the state used to validate the movement of a player.
+// It is only accessed by the goroutine that handles incoming packets, with
+// the exception of grace.
+type movementChecker struct {
+	distance tokenBucket
+	groundY  float64
+	grace    int64
+}
+
+func newMovementChecker() movementChecker {
+	return movementChecker{
+		distance: newTokenBucket(RateLimit{maxWalkSpeed, maxWalkBurst}),
+	}
+}
+
+// allowMovement disables the movement checks for the specified duration,
+// which gives the client time to apply a teleport or velocity change.
+func (player *Player) allowMovement(d time.Duration) {
+	until := time.Now().Add(d).UnixNano()
+	if until > atomic.LoadInt64(&player.movement.grace) {
+		atomic.StoreInt64(&player.movement.grace, until)
+	}
+}
+
+// checkMovement validates a movement of the player from one location to
+// another against the HackConfig of the level. It returns the violation, if
+// any, and whether the movement is valid.
+func (player *Player) checkMovement(level *Level, from, to Location) (int, bool) {
+	checker := &player.movement
+	now := time.Now()
+	feet := to.Y - playerEyeHeight
+	if now.UnixNano() < atomic.LoadInt64(&checker.grace) {
+		checker.distance.tokens = checker.distance.burst
+		checker.distance.last = now
+		checker.groundY = feet
+		return 0, true
+	}
+
+	config := &level.HackConfig
+	if !config.Speeding {
+		dx, dz := to.X-from.X, to.Z-from.Z
+		cost := math.Sqrt(dx*dx+dz*dz) / level.speedFactor(from)
+		if !checker.distance.takeAmount(now, cost) {
+			return MovementViolationSpeed, false
+		}
+	}
+
+	if !config.NoClip && player.canOccupy(level, from) && !player.canOccupy(level, to) {
+		return MovementViolationNoClip, false
+	}
+
+	if !config.Flying {
+		if level.isGrounded(to) {
+			checker.groundY = feet
+		} else {
+			jumpHeight := config.JumpHeight
+			if jumpHeight < 0 {
+				jumpHeight = defaultJumpHeight
+			}
+
+			if feet > checker.groundY+jumpHeight+jumpSlack {
+				return MovementViolationVertical, false
+			}
+		}
+	}
+
+	return 0, true
+}
+
+// speedFactor returns the walking speed multiplier of the block that the
+// location is standing on.
+func (level *Level) speedFactor(location Location) float64 {
+	x, z := int(math.Floor(location.X)), int(math.Floor(location.Z))
+	y := int(math.Floor(location.Y - playerEyeHeight - 0.05))
+	if !level.InBounds(x, y, z) {
+		return 1
+	}
+
+	if def := level.BlockDef(level.GetBlock(x, y, z)); def != nil && def.Speed > 1 {
+		return def.Speed
+	}
+
+	return 1
+}
+
+// isGrounded reports whether a player at the specified location is standing
+// on a solid block, swimming or climbing.
+func (level *Level) isGrounded(location Location) bool {
+	x, z := int(math.Floor(location.X)), int(math.Floor(location.Z))
+	feet := location.Y - playerEyeHeight
+	for y := int(math.Floor(feet - 0.05)); y <= int(math.Floor(feet+1)); y++ {
+		if !level.InBounds(x, y, z) {
+			continue
+		}
+
+		block := level.GetBlock(x, y, z)
+		switch level.CollideMode(block) {
+		case CollideModeSwim:
+			return true
+		case CollideModeSolid:
+			if float64(y) < feet {
+				return true
+			}
+		default:
+			if block == BlockRope {
+				return true
+			}
+		}
+	}
+
+	return false
+}
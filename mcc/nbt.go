@@ -182,11 +182,15 @@ func (nbt *nbtEncoder) writeCompound(v reflect.Value) error {
 				continue
 			}
 
-			fname := tag
+			fname, opts := parseNbtTag(tag)
 			if fname == "" {
 				fname = field.Name
 			}
 
+			if opts == "omitempty" && isEmptyValue(v.Field(i)) {
+				continue
+			}
+
 			if err := nbt.writeTag(fname, v.Field(i)); err != nil {
 				return err
 			}
@@ -196,6 +200,36 @@ func (nbt *nbtEncoder) writeCompound(v reflect.Value) error {
 	return nil
 }
 
+// parseNbtTag splits a struct field tag into the tag name and its options.
+// The only supported option is omitempty, which skips empty arrays, lists,
+// strings and maps, and zero numbers when encoding.
+func parseNbtTag(tag string) (name, opts string) {
+	if i := strings.Index(tag, ","); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+
+	return tag, ""
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+
+	return false
+}
+
 func (nbt *nbtEncoder) writeIntArray(tag []int32) error {
 	if err := nbt.writeInt(int32(len(tag))); err != nil {
 		return err
@@ -471,7 +505,8 @@ func (nbt *nbtDecoder) readTag(v reflect.Value) (tagType byte, err error) {
 		for i := 0; i < v.NumField(); i++ {
 			field := v.Type().Field(i)
 			fname := strings.ToLower(field.Name)
-			tag := strings.ToLower(field.Tag.Get("nbt"))
+			tag, _ := parseNbtTag(field.Tag.Get("nbt"))
+			tag = strings.ToLower(tag)
 			if tag == key || (tag == "" && fname == key) {
 				target = v.Field(i)
 				break
@@ -0,0 +1,52 @@
+package mcc_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+)
+
+type nbtOmitEmpty struct {
+	Byte   byte    `nbt:",omitempty"`
+	Short  int16   `nbt:",omitempty"`
+	Int    int32   `nbt:",omitempty"`
+	Float  float32 `nbt:",omitempty"`
+	String string  `nbt:",omitempty"`
+	Bytes  []byte  `nbt:",omitempty"`
+	Kept   int32
+}
+
+func TestNbtOmitEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := mcc.NbtMarshal(&buf, "Root", nbtOmitEmpty{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"Byte", "Short", "Int", "Float", "String", "Bytes"} {
+		if bytes.Contains(buf.Bytes(), []byte(name)) {
+			t.Errorf("empty field %s was encoded", name)
+		}
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("Kept")) {
+		t.Error("field Kept without omitempty was not encoded")
+	}
+
+	want := nbtOmitEmpty{1, 2, 3, 4.5, "five", []byte{6}, 7}
+	buf.Reset()
+	if err := mcc.NbtMarshal(&buf, "Root", want); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct{ Root nbtOmitEmpty }
+	if err := mcc.NbtUnmarshal(&buf, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Root.Byte != want.Byte || got.Root.Short != want.Short ||
+		got.Root.Int != want.Int || got.Root.Float != want.Float ||
+		got.Root.String != want.String || !bytes.Equal(got.Root.Bytes, want.Bytes) ||
+		got.Root.Kept != want.Kept {
+		t.Errorf("NbtUnmarshal() = %+v, want %+v", got.Root, want)
+	}
+}
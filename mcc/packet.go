@@ -1,12 +1,11 @@
 package mcc
 
 import (
-	"bytes"
 	"encoding/binary"
-	"log"
 	"math"
-	"strings"
 	"time"
+
+	"github.com/AndreasGoulas/go-mcc/mcc/internal/proto"
 )
 
 const (
@@ -36,8 +35,16 @@ const (
 	CpeInstantMOTD
 	CpeFastMap
 	CpeExtendedTextures
-
-	CpeMax   = CpeExtendedTextures
+	CpeExtendedBlocks
+	CpeCustomModels
+	CpeCustomParticles
+	CpeVelocityControl
+	CpePluginMessages
+	CpeSetHotbar
+	CpeExtEntityTeleport
+	CpeSetSpawnpoint
+
+	CpeMax   = CpeSetSpawnpoint
 	CpeCount = CpeMax + 1
 )
 
@@ -73,100 +80,22 @@ var Extensions = [CpeCount]ExtEntry{
 	{"InstantMOTD", 1},
 	{"FastMap", 1},
 	{"ExtendedTextures", 1},
-}
-
-const (
-	packetTypeIdentification            = 0x00
-	packetTypePing                      = 0x01
-	packetTypeLevelInitialize           = 0x02
-	packetTypeLevelDataChunk            = 0x03
-	packetTypeLevelFinalize             = 0x04
-	packetTypeSetBlockClient            = 0x05
-	packetTypeSetBlock                  = 0x06
-	packetTypeAddEntity                 = 0x07
-	packetTypePlayerTeleport            = 0x08
-	packetTypePositionOrientationUpdate = 0x09
-	packetTypePositionUpdate            = 0x0a
-	packetTypeOrientationUpdate         = 0x0b
-	packetTypeRemoveEntity              = 0x0c
-	packetTypeMessage                   = 0x0d
-	packetTypeKick                      = 0x0e
-	packetTypeUpdateUserType            = 0x0f
-	packetTypeExtInfo                   = 0x10
-	packetTypeExtEntry                  = 0x11
-	packetTypeSetClickDistance          = 0x12
-	packetTypeCustomBlockSupportLevel   = 0x13
-	packetTypeHoldThis                  = 0x14
-	packetTypeSetTextHotKey             = 0x15
-	packetTypeExtAddPlayerName          = 0x16
-	packetTypeExtRemovePlayerName       = 0x18
-	packetTypeEnvSetColor               = 0x19
-	packetTypeMakeSelection             = 0x1a
-	packetTypeRemoveSelection           = 0x1b
-	packetTypeSetBlockPermission        = 0x1c
-	packetTypeChangeModel               = 0x1d
-	packetTypeEnvSetWeatherType         = 0x1f
-	packetTypeHackControl               = 0x20
-	packetTypeExtAddEntity2             = 0x21
-	packetTypePlayerClicked             = 0x22
-	packetTypeDefineBlock               = 0x23
-	packetTypeRemoveBlockDefinition     = 0x24
-	packetTypeDefineBlockExt            = 0x25
-	packetTypeBulkBlockUpdate           = 0x26
-	packetTypeSetTextColor              = 0x27
-	packetTypeSetMapEnvUrl              = 0x28
-	packetTypeSetMapEnvProperty         = 0x29
-	packetTypeSetEntityProperty         = 0x2a
-	packetTypeTwoWayPing                = 0x2b
-	packetTypeSetInventoryOrder         = 0x2c
-)
-
-func padString(str string) [64]byte {
-	var result [64]byte
-	copy(result[:], str)
-	if len(str) < 64 {
-		copy(result[len(str):], bytes.Repeat([]byte{' '}, 64-len(str)))
-	}
-
-	return result
-}
-
-func trimString(str [64]byte) string {
-	return strings.TrimRight(string(str[:]), " ")
+	{"ExtendedBlocks", 1},
+	{"CustomModels", 2},
+	{"CustomParticles", 1},
+	{"VelocityControl", 1},
+	{"PluginMessages", 1},
+	{"SetHotbar", 1},
+	{"ExtEntityTeleport", 1},
+	{"SetSpawnpoint", 1},
 }
 
 type packet struct {
-	bytes.Buffer
-}
-
-func (packet *packet) marshal(v interface{}) {
-	if err := binary.Write(packet, binary.BigEndian, v); err != nil {
-		log.Printf("packet: %s\n", err)
-	}
+	proto.Packet
 }
 
 func (packet *packet) position(location Location, extPos bool) {
-	if extPos {
-		packet.marshal(struct{ X, Y, Z int32 }{
-			int32(location.X * 32),
-			int32(location.Y * 32),
-			int32(location.Z * 32),
-		})
-	} else {
-		packet.marshal(struct{ X, Y, Z int16 }{
-			int16(location.X * 32),
-			int16(location.Y * 32),
-			int16(location.Z * 32),
-		})
-	}
-}
-
-func (packet *packet) textureID(textureID int, extTex bool) {
-	if extTex {
-		packet.marshal(int16(textureID))
-	} else {
-		packet.WriteByte(byte(textureID))
-	}
+	packet.Position(location.X, location.Y, location.Z, extPos)
 }
 
 func (packet *packet) motd(player *Player, motd string, op bool) {
@@ -175,101 +104,102 @@ func (packet *packet) motd(player *Player, motd string, op bool) {
 		userType = 0x64
 	}
 
-	packet.marshal(struct {
-		PacketID        byte
-		ProtocolVersion byte
-		Name            [64]byte
-		MOTD            [64]byte
-		UserType        byte
-	}{
-		packetTypeIdentification,
-		0x07,
-		padString(player.server.Config.Name),
-		padString(motd),
-		userType,
-	})
+	packet.Identification(player.server.Config.Name, motd, userType)
 }
 
 func (packet *packet) ping() {
-	packet.WriteByte(packetTypePing)
+	packet.WriteByte(proto.PacketTypePing)
 }
 
 func (packet *packet) levelInitialize() {
-	packet.WriteByte(packetTypeLevelInitialize)
+	packet.WriteByte(proto.PacketTypeLevelInitialize)
 }
 
 func (packet *packet) levelInitializeExt(size int) {
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		PacketID byte
 		Size     int32
-	}{packetTypeLevelInitialize, int32(size)})
+	}{proto.PacketTypeLevelInitialize, int32(size)})
 }
 
 func (packet *packet) levelFinalize(x, y, z int) {
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		PacketID byte
 		X, Y, Z  int16
-	}{packetTypeLevelFinalize, int16(x), int16(y), int16(z)})
+	}{proto.PacketTypeLevelFinalize, int16(x), int16(y), int16(z)})
 }
 
-func (packet *packet) setBlock(x, y, z int, block byte) {
-	packet.marshal(struct {
-		PacketID  byte
-		X, Y, Z   int16
-		BlockType byte
-	}{packetTypeSetBlock, int16(x), int16(y), int16(z), block})
+func (packet *packet) setBlock(x, y, z int, block uint16, extBlocks bool) {
+	packet.Marshal(struct {
+		PacketID byte
+		X, Y, Z  int16
+	}{proto.PacketTypeSetBlock, int16(x), int16(y), int16(z)})
+	packet.BlockID(block, extBlocks)
 }
 
-func (packet *packet) addEntity(entity *Entity, self bool, extPos bool) {
-	id := entity.id
-	if self {
-		id = 0xff
-	}
-
-	location := entity.location
-	packet.marshal(struct {
+func (packet *packet) addEntity(entity *Entity, id byte, extPos bool) {
+	location := entity.Location()
+	packet.Marshal(struct {
 		PacketID byte
 		PlayerID byte
 		Name     [64]byte
-	}{packetTypeAddEntity, id, padString(entity.DisplayName)})
+	}{proto.PacketTypeAddEntity, id, proto.PadString(entity.DisplayName)})
 
 	packet.position(location, extPos)
-	packet.marshal(struct{ Yaw, Pitch byte }{
+	packet.Marshal(struct{ Yaw, Pitch byte }{
 		byte(location.Yaw * 256 / 360),
 		byte(location.Pitch * 256 / 360),
 	})
 }
 
-func (packet *packet) teleport(entity *Entity, self bool, extPos bool) {
-	id := entity.id
-	if self {
-		id = 0xff
-	}
-
-	location := entity.location
-	packet.marshal(struct {
+func (packet *packet) teleport(id byte, location Location, extPos bool) {
+	packet.Marshal(struct {
 		PacketID byte
 		PlayerID byte
-	}{packetTypePlayerTeleport, id})
+	}{proto.PacketTypePlayerTeleport, id})
 
 	packet.position(location, extPos)
-	packet.marshal(struct{ Yaw, Pitch byte }{
+	packet.Marshal(struct{ Yaw, Pitch byte }{
 		byte(location.Yaw * 256 / 360),
 		byte(location.Pitch * 256 / 360),
 	})
 }
 
-func (packet *packet) positionOrientationUpdate(entity *Entity) {
-	location := entity.location
-	lastLocation := entity.lastLocation
-	packet.marshal(struct {
+const (
+	extTeleportUsePosition         = 1 << 0
+	extTeleportAbsoluteInstant     = 0 << 1
+	extTeleportAbsoluteSmooth      = 1 << 1
+	extTeleportRelativeSmooth      = 2 << 1
+	extTeleportRelativeSeamless    = 3 << 1
+	extTeleportUseOrientation      = 1 << 4
+	extTeleportInterpolateRotation = 1 << 5
+)
+
+// extEntityTeleport writes an ExtEntityTeleport packet. If the flags specify
+// a relative mode, location holds the offset from the last position.
+func (packet *packet) extEntityTeleport(id byte, flags byte, location Location, extPos bool) {
+	packet.Marshal(struct {
+		PacketID byte
+		EntityID byte
+		Flags    byte
+	}{proto.PacketTypeExtEntityTeleport, id, flags})
+
+	packet.position(location, extPos)
+	packet.Marshal(struct{ Yaw, Pitch byte }{
+		byte(location.Yaw * 256 / 360),
+		byte(location.Pitch * 256 / 360),
+	})
+}
+
+func (packet *packet) positionOrientationUpdate(id byte, location, lastLocation Location) {
+	packet.Marshal(struct {
 		PacketID   byte
 		PlayerID   byte
 		X, Y, Z    byte
 		Yaw, Pitch byte
 	}{
-		packetTypePositionOrientationUpdate,
-		entity.id,
+		proto.PacketTypePositionOrientationUpdate,
+		id,
 		byte((location.X - lastLocation.X) * 32),
 		byte((location.Y - lastLocation.Y) * 32),
 		byte((location.Z - lastLocation.Z) * 32),
@@ -278,61 +208,45 @@ func (packet *packet) positionOrientationUpdate(entity *Entity) {
 	})
 }
 
-func (packet *packet) positionUpdate(entity *Entity) {
-	location := entity.location
-	lastLocation := entity.lastLocation
-	packet.marshal(struct {
+func (packet *packet) positionUpdate(id byte, location, lastLocation Location) {
+	packet.Marshal(struct {
 		PacketID byte
 		PlayerID byte
 		X, Y, Z  byte
 	}{
-		packetTypePositionUpdate,
-		entity.id,
+		proto.PacketTypePositionUpdate,
+		id,
 		byte((location.X - lastLocation.X) * 32),
 		byte((location.Y - lastLocation.Y) * 32),
 		byte((location.Z - lastLocation.Z) * 32),
 	})
 }
 
-func (packet *packet) orientationUpdate(entity *Entity) {
-	location := entity.location
-	packet.marshal(struct {
+func (packet *packet) orientationUpdate(id byte, location Location) {
+	packet.Marshal(struct {
 		PacketID   byte
 		PlayerID   byte
 		Yaw, Pitch byte
 	}{
-		packetTypeOrientationUpdate,
-		entity.id,
+		proto.PacketTypeOrientationUpdate,
+		id,
 		byte(location.Yaw * 256 / 360),
 		byte(location.Pitch * 256 / 360),
 	})
 }
 
-func (packet *packet) removeEntity(entity *Entity, self bool) {
-	id := entity.id
-	if self {
-		id = 0xff
-	}
-
-	packet.marshal(struct {
-		PacketID byte
-		PlayerID byte
-	}{packetTypeRemoveEntity, id})
-}
-
-func (packet *packet) message(msgType int, message string) {
-	packet.marshal(struct {
+func (packet *packet) removeEntity(id byte) {
+	packet.Marshal(struct {
 		PacketID byte
 		PlayerID byte
-		Message  [64]byte
-	}{packetTypeMessage, byte(msgType), padString(message)})
+	}{proto.PacketTypeRemoveEntity, id})
 }
 
 func (packet *packet) kick(reason string) {
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		PacketID byte
 		Reason   [64]byte
-	}{packetTypeKick, padString(reason)})
+	}{proto.PacketTypeKick, proto.PadString(reason)})
 }
 
 func (packet *packet) updateUserType(op bool) {
@@ -341,78 +255,48 @@ func (packet *packet) updateUserType(op bool) {
 		userType = 0x64
 	}
 
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		PacketID byte
 		UserType byte
-	}{packetTypeUpdateUserType, userType})
-}
-
-func (packet *packet) extInfo() {
-	packet.marshal(struct {
-		PacketID       byte
-		AppName        [64]byte
-		ExtensionCount int16
-	}{packetTypeExtInfo, padString(ServerSoftware), int16(len(Extensions))})
-}
-
-func (packet *packet) extEntry(entry *ExtEntry) {
-	packet.marshal(struct {
-		PacketID byte
-		ExtName  [64]byte
-		Version  int32
-	}{packetTypeExtEntry, padString(entry.Name), int32(entry.Version)})
+	}{proto.PacketTypeUpdateUserType, userType})
 }
 
 func (packet *packet) clickDistance(dist float64) {
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		PacketID byte
 		Distance int16
-	}{packetTypeSetClickDistance, int16(dist * 32)})
-}
-
-func (packet *packet) customBlockSupportLevel(level byte) {
-	packet.marshal(struct {
-		PacketID     byte
-		SupportLevel byte
-	}{packetTypeCustomBlockSupportLevel, level})
+	}{proto.PacketTypeSetClickDistance, int16(dist * 32)})
 }
 
-func (packet *packet) holdThis(block byte, lock bool) {
+func (packet *packet) holdThis(block uint16, lock bool, extBlocks bool) {
 	preventChange := byte(0)
 	if lock {
 		preventChange = 1
 	}
 
-	packet.marshal(struct {
-		PacketID      byte
-		BlockToHold   byte
-		PreventChange byte
-	}{packetTypeHoldThis, block, preventChange})
+	packet.WriteByte(proto.PacketTypeHoldThis)
+	packet.BlockID(block, extBlocks)
+	packet.WriteByte(preventChange)
 }
 
 func (packet *packet) setTextHotKey(hotkey *HotkeyDesc) {
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		PacketID byte
 		Label    [64]byte
 		Action   [64]byte
 		KeyCode  int32
 		KeyMods  byte
 	}{
-		packetTypeSetTextHotKey,
-		padString(hotkey.Label),
-		padString(hotkey.Action),
+		proto.PacketTypeSetTextHotKey,
+		proto.PadString(hotkey.Label),
+		proto.PadString(hotkey.Action),
 		int32(hotkey.Key),
 		hotkey.KeyMods,
 	})
 }
 
-func (packet *packet) extAddPlayerName(entity *Entity, self bool) {
-	id := int16(entity.id)
-	if self {
-		id = 0xff
-	}
-
-	packet.marshal(struct {
+func (packet *packet) extAddPlayerName(entity *Entity, id byte) {
+	packet.Marshal(struct {
 		PacketID   byte
 		NameID     int16
 		PlayerName [64]byte
@@ -420,29 +304,24 @@ func (packet *packet) extAddPlayerName(entity *Entity, self bool) {
 		GroupName  [64]byte
 		GroupRank  byte
 	}{
-		packetTypeExtAddPlayerName,
-		id,
-		padString(entity.name),
-		padString(entity.ListName),
-		padString(entity.GroupName),
+		proto.PacketTypeExtAddPlayerName,
+		int16(id),
+		proto.PadString(entity.name),
+		proto.PadString(entity.ListName),
+		proto.PadString(entity.GroupName),
 		entity.GroupRank,
 	})
 }
 
-func (packet *packet) extRemovePlayerName(entity *Entity, self bool) {
-	id := int16(entity.id)
-	if self {
-		id = 0xff
-	}
-
-	packet.marshal(struct {
+func (packet *packet) extRemovePlayerName(id byte) {
+	packet.Marshal(struct {
 		PacketID byte
 		NameID   int16
-	}{packetTypeExtRemovePlayerName, id})
+	}{proto.PacketTypeExtRemovePlayerName, int16(id)})
 }
 
 func (packet *packet) makeSelection(id byte, label string, box AABB, color RGBA) {
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		PacketID               byte
 		SelectionID            byte
 		Label                  [64]byte
@@ -450,9 +329,9 @@ func (packet *packet) makeSelection(id byte, label string, box AABB, color RGBA)
 		EndX, EndY, Endz       int16
 		R, G, B, Opacity       int16
 	}{
-		packetTypeMakeSelection,
+		proto.PacketTypeMakeSelection,
 		id,
-		padString(label),
+		proto.PadString(label),
 		int16(box.Min.X), int16(box.Min.Y), int16(box.Min.Z),
 		int16(box.Max.X), int16(box.Max.Y), int16(box.Max.Z),
 		int16(color.R), int16(color.G), int16(color.B), int16(color.A),
@@ -460,10 +339,10 @@ func (packet *packet) makeSelection(id byte, label string, box AABB, color RGBA)
 }
 
 func (packet *packet) removeSelection(id byte) {
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		PacketID    byte
 		SelectionID byte
-	}{packetTypeRemoveSelection, id})
+	}{proto.PacketTypeRemoveSelection, id})
 }
 
 func (packet *packet) envSetColor(id byte, color NullRGB) {
@@ -471,23 +350,21 @@ func (packet *packet) envSetColor(id byte, color NullRGB) {
 		packetId byte
 		Variable byte
 		R, G, B  int16
-	}{packetTypeEnvSetColor, id, -1, -1, -1}
+	}{proto.PacketTypeEnvSetColor, id, -1, -1, -1}
 	if color.Valid {
 		data.R = int16(color.R)
 		data.G = int16(color.G)
 		data.B = int16(color.B)
 	}
 
-	packet.marshal(data)
+	packet.Marshal(data)
 }
 
-func (packet *packet) setBlockPermission(id byte, canPlace, canBreak bool) {
+func (packet *packet) setBlockPermission(id uint16, canPlace, canBreak bool, extBlocks bool) {
 	data := struct {
-		PacketID       byte
-		BlockType      byte
 		AllowPlacement byte
 		AllowDeletion  byte
-	}{packetTypeSetBlockPermission, id, 0, 0}
+	}{0, 0}
 	if canPlace {
 		data.AllowPlacement = 1
 	}
@@ -495,27 +372,209 @@ func (packet *packet) setBlockPermission(id byte, canPlace, canBreak bool) {
 		data.AllowDeletion = 1
 	}
 
-	packet.marshal(data)
+	packet.WriteByte(proto.PacketTypeSetBlockPermission)
+	packet.BlockID(id, extBlocks)
+	packet.Marshal(data)
 }
 
-func (packet *packet) changeModel(entity *Entity, self bool) {
-	id := entity.id
-	if self {
-		id = 0xff
-	}
-
-	packet.marshal(struct {
+func (packet *packet) changeModel(id byte, model string) {
+	packet.Marshal(struct {
 		PacketID  byte
 		EntityID  byte
 		ModelName [64]byte
-	}{packetTypeChangeModel, id, padString(entity.Model)})
+	}{proto.PacketTypeChangeModel, id, proto.PadString(model)})
+}
+
+func (packet *packet) setHotbar(slot byte, block uint16, extBlocks bool) {
+	packet.WriteByte(proto.PacketTypeSetHotbar)
+	packet.BlockID(block, extBlocks)
+	packet.WriteByte(slot)
+}
+
+func (packet *packet) setSpawnpoint(location Location, extPos bool) {
+	packet.WriteByte(proto.PacketTypeSetSpawnpoint)
+	packet.position(location, extPos)
+	packet.Marshal(struct{ Yaw, Pitch byte }{
+		byte(location.Yaw * 256 / 360),
+		byte(location.Pitch * 256 / 360),
+	})
+}
+
+func (packet *packet) velocityControl(x, y, z float64, mode byte) {
+	packet.Marshal(struct {
+		PacketID            byte
+		X, Y, Z             int32
+		ModeX, ModeY, ModeZ byte
+	}{
+		proto.PacketTypeVelocityControl,
+		int32(x * 10000), int32(y * 10000), int32(z * 10000),
+		mode, mode, mode,
+	})
+}
+
+func (packet *packet) defineEffect(particle *Particle) {
+	fullBright := byte(0)
+	if particle.FullBright {
+		fullBright = 1
+	}
+
+	packet.Marshal(struct {
+		PacketID          byte
+		EffectID          byte
+		U1, V1, U2, V2    byte
+		R, G, B           byte
+		FrameCount        byte
+		ParticleCount     byte
+		Size              byte
+		SizeVariation     int32
+		Spread            uint16
+		Speed             int32
+		Gravity           int32
+		BaseLifetime      int32
+		LifetimeVariation int32
+		CollideFlags      byte
+		FullBright        byte
+	}{
+		proto.PacketTypeDefineEffect,
+		particle.ID,
+		particle.U1, particle.V1, particle.U2, particle.V2,
+		particle.Tint.R, particle.Tint.G, particle.Tint.B,
+		particle.FrameCount,
+		particle.ParticleCount,
+		byte(particle.Size * 32),
+		int32(particle.SizeVariation * 10000),
+		uint16(particle.Spread * 32),
+		int32(particle.Speed * 10000),
+		int32(particle.Gravity * 10000),
+		int32(particle.Lifetime * 10000),
+		int32(particle.LifetimeVariation * 10000),
+		particle.CollideFlags,
+		fullBright,
+	})
+}
+
+func (packet *packet) spawnEffect(x, y, z float64, origin Vector3F, effectID byte) {
+	packet.Marshal(struct {
+		PacketID                  byte
+		EffectID                  byte
+		X, Y, Z                   int32
+		OriginX, OriginY, OriginZ int32
+	}{
+		proto.PacketTypeSpawnEffect,
+		effectID,
+		int32(x * 32), int32(y * 32), int32(z * 32),
+		int32(origin.X * 32), int32(origin.Y * 32), int32(origin.Z * 32),
+	})
+}
+
+type modelVector struct {
+	X, Y, Z float32
+}
+
+func encodeModelVector(v Vector3F) modelVector {
+	return modelVector{float32(v.X), float32(v.Y), float32(v.Z)}
+}
+
+func (packet *packet) defineModel(model *Model) {
+	flags := byte(0)
+	if model.Bobbing {
+		flags |= 1 << 0
+	}
+	if model.Pushes {
+		flags |= 1 << 1
+	}
+	if model.UsesHumanSkin {
+		flags |= 1 << 2
+	}
+	if model.CalcHumanAnims {
+		flags |= 1 << 3
+	}
+
+	packet.Marshal(struct {
+		PacketID   byte
+		ModelID    byte
+		Name       [64]byte
+		Flags      byte
+		NameY      float32
+		EyeY       float32
+		Collision  modelVector
+		PickingMin modelVector
+		PickingMax modelVector
+		UScale     uint16
+		VScale     uint16
+		PartCount  byte
+	}{
+		proto.PacketTypeDefineModel,
+		model.id,
+		proto.PadString(model.Name),
+		flags,
+		float32(model.NameY),
+		float32(model.EyeY),
+		encodeModelVector(model.Collision),
+		encodeModelVector(model.PickingMin),
+		encodeModelVector(model.PickingMax),
+		model.UScale,
+		model.VScale,
+		byte(len(model.Parts)),
+	})
+}
+
+func (packet *packet) defineModelPart(model *Model, part *ModelPart) {
+	type anim struct {
+		Flags      byte
+		A, B, C, D float32
+	}
+
+	var anims [MaxModelAnims]anim
+	for i, a := range part.Anims {
+		anims[i] = anim{
+			a.Type&0x3f | a.Axis<<6,
+			float32(a.A), float32(a.B), float32(a.C), float32(a.D),
+		}
+	}
+
+	flags := byte(0)
+	if part.FullBright {
+		flags |= 1 << 0
+	}
+	if part.FirstPersonArm {
+		flags |= 1 << 1
+	}
+
+	packet.Marshal(struct {
+		PacketID       byte
+		ModelID        byte
+		Min, Max       modelVector
+		UV             ModelUVs
+		RotationOrigin modelVector
+		Rotation       modelVector
+		Anims          [MaxModelAnims]anim
+		Flags          byte
+	}{
+		proto.PacketTypeDefineModelPart,
+		model.id,
+		encodeModelVector(part.Min),
+		encodeModelVector(part.Max),
+		part.UV,
+		encodeModelVector(part.RotationOrigin),
+		encodeModelVector(part.Rotation),
+		anims,
+		flags,
+	})
+}
+
+func (packet *packet) undefineModel(model *Model) {
+	packet.Marshal(struct {
+		PacketID byte
+		ModelID  byte
+	}{proto.PacketTypeUndefineModel, model.id})
 }
 
 func (packet *packet) envWeatherType(weather byte) {
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		PacketID    byte
 		WeatherType byte
-	}{packetTypeEnvSetWeatherType, weather})
+	}{proto.PacketTypeEnvSetWeatherType, weather})
 }
 
 func (packet *packet) hackControl(config *HackConfig) {
@@ -527,7 +586,7 @@ func (packet *packet) hackControl(config *HackConfig) {
 		SpawnControl    byte
 		ThirdPersonView byte
 		JumpHeight      int16
-	}{packetTypeHackControl, 0, 0, 0, 0, 0, -1}
+	}{proto.PacketTypeHackControl, 0, 0, 0, 0, 0, -1}
 
 	if config.Flying {
 		data.Flying = 1
@@ -548,65 +607,58 @@ func (packet *packet) hackControl(config *HackConfig) {
 		data.JumpHeight = int16(config.JumpHeight * 32)
 	}
 
-	packet.marshal(data)
+	packet.Marshal(data)
 }
 
-func (packet *packet) extAddEntity2(entity *Entity, self bool, extPos bool) {
-	id := entity.id
-	if self {
-		id = 0xff
-	}
-
-	location := entity.location
-	packet.marshal(struct {
+func (packet *packet) extAddEntity2(entity *Entity, id byte, extPos bool) {
+	location := entity.Location()
+	packet.Marshal(struct {
 		PacketID    byte
 		EntityID    byte
 		DisplayName [64]byte
 		SkinName    [64]byte
 	}{
-		packetTypeExtAddEntity2,
+		proto.PacketTypeExtAddEntity2,
 		id,
-		padString(entity.DisplayName),
-		padString(entity.SkinName),
+		proto.PadString(entity.DisplayName),
+		proto.PadString(entity.SkinName),
 	})
 
 	packet.position(location, extPos)
-	packet.marshal(struct{ Yaw, Pitch byte }{
+	packet.Marshal(struct{ Yaw, Pitch byte }{
 		byte(location.Yaw * 256 / 360),
 		byte(location.Pitch * 256 / 360),
 	})
 }
 
-func (packet *packet) defineBlock(id byte, block *BlockDefinition, ext bool, extTex bool) {
-	packetID := byte(packetTypeDefineBlock)
+func (packet *packet) defineBlock(id uint16, block *BlockDefinition, ext bool, extTex bool, extBlocks bool) {
+	packetID := byte(proto.PacketTypeDefineBlock)
 	if ext {
-		packetID = packetTypeDefineBlockExt
+		packetID = proto.PacketTypeDefineBlockExt
 	}
 
-	packet.marshal(struct {
-		PacketID      byte
-		BlockID       byte
+	packet.WriteByte(packetID)
+	packet.BlockID(id, extBlocks)
+	packet.Marshal(struct {
 		Name          [64]byte
 		Solidity      byte
 		MovementSpeed byte
 	}{
-		packetID,
-		id,
-		padString(block.Name),
+		proto.PadString(block.Name),
 		block.CollideMode,
 		byte(64*math.Log2(block.Speed) + 128),
 	})
 
-	packet.textureID(block.Textures[FacePosY], extTex)
+	packet.TextureID(block.Textures[FacePosY], extTex)
 	if ext {
-		packet.textureID(block.Textures[FaceNegX], extTex)
-		packet.textureID(block.Textures[FacePosX], extTex)
-		packet.textureID(block.Textures[FaceNegZ], extTex)
-		packet.textureID(block.Textures[FacePosZ], extTex)
+		packet.TextureID(block.Textures[FaceNegX], extTex)
+		packet.TextureID(block.Textures[FacePosX], extTex)
+		packet.TextureID(block.Textures[FaceNegZ], extTex)
+		packet.TextureID(block.Textures[FacePosZ], extTex)
 	} else {
-		packet.textureID(block.Textures[FacePosX], extTex)
+		packet.TextureID(block.Textures[FacePosX], extTex)
 	}
-	packet.textureID(block.Textures[FaceNegY], extTex)
+	packet.TextureID(block.Textures[FaceNegY], extTex)
 
 	transmitsLight := byte(1)
 	if block.BlockLight {
@@ -618,7 +670,7 @@ func (packet *packet) defineBlock(id byte, block *BlockDefinition, ext bool, ext
 		fullBright = 1
 	}
 
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		TransmitsLight byte
 		WalkSound      byte
 		FullBright     byte
@@ -626,7 +678,7 @@ func (packet *packet) defineBlock(id byte, block *BlockDefinition, ext bool, ext
 
 	if ext {
 		aabb := block.AABB
-		packet.marshal(struct {
+		packet.Marshal(struct {
 			MinX, MinY, MinZ byte
 			MaxX, MaxY, MaxZ byte
 		}{
@@ -637,7 +689,7 @@ func (packet *packet) defineBlock(id byte, block *BlockDefinition, ext bool, ext
 		packet.WriteByte(block.Shape)
 	}
 
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		BlockDraw        byte
 		FogDensity       byte
 		FogR, FogG, FogB byte
@@ -648,98 +700,92 @@ func (packet *packet) defineBlock(id byte, block *BlockDefinition, ext bool, ext
 	})
 }
 
-func (packet *packet) removeBlockDefinition(id byte) {
-	packet.marshal(struct {
-		PacketID byte
-		BlockID  byte
-	}{packetTypeRemoveBlockDefinition, id})
+func (packet *packet) removeBlockDefinition(id uint16, extBlocks bool) {
+	packet.WriteByte(proto.PacketTypeRemoveBlockDefinition)
+	packet.BlockID(id, extBlocks)
 }
 
-func (packet *packet) bulkBlockUpdate(indices []int32, blocks []byte) {
+func (packet *packet) bulkBlockUpdate(indices []int32, blocks []uint16, extBlocks bool) {
 	data := struct {
 		PacketID byte
 		Count    byte
 		Indices  [256]int32
 		Blocks   [256]byte
 	}{
-		packetTypeBulkBlockUpdate,
-		byte(len(indices)),
+		proto.PacketTypeBulkBlockUpdate,
+		byte(len(indices) - 1),
 		[256]int32{},
 		[256]byte{},
 	}
 
 	copy(data.Indices[:], indices)
-	copy(data.Blocks[:], blocks)
-	packet.marshal(data)
+	for i, block := range blocks {
+		data.Blocks[i] = byte(block)
+	}
+	packet.Marshal(data)
+
+	if extBlocks {
+		var upper [64]byte
+		for i, block := range blocks {
+			upper[i/4] |= byte(block>>8&3) << (uint(i%4) * 2)
+		}
+		packet.Write(upper[:])
+	}
 }
 
 func (packet *packet) setTextColor(color *ColorDesc) {
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		PacketID   byte
 		R, G, B, A byte
 		Code       byte
 	}{
-		packetTypeSetTextColor,
+		proto.PacketTypeSetTextColor,
 		color.R, color.G, color.B, color.A,
 		color.Code,
 	})
 }
 
 func (packet *packet) mapEnvUrl(texturePack string) {
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		PacketID       byte
 		TexturePackURL [64]byte
-	}{packetTypeSetMapEnvUrl, padString(texturePack)})
+	}{proto.PacketTypeSetMapEnvUrl, proto.PadString(texturePack)})
 }
 
 func (packet *packet) mapEnvProperty(id byte, value int32) {
-	packet.marshal(struct {
+	packet.Marshal(struct {
 		PacketID byte
 		Type     byte
 		Value    int32
-	}{packetTypeSetMapEnvProperty, id, value})
+	}{proto.PacketTypeSetMapEnvProperty, id, value})
 }
 
-func (packet *packet) entityProperty(entity *Entity, self bool, prop byte, value int32) {
-	id := entity.id
-	if self {
-		id = 0xff
-	}
-
-	packet.marshal(struct {
+func (packet *packet) entityProperty(id byte, prop byte, value int32) {
+	packet.Marshal(struct {
 		PacketID byte
 		EntityID byte
 		Type     byte
 		Value    int32
-	}{packetTypeSetEntityProperty, id, prop, value})
+	}{proto.PacketTypeSetEntityProperty, id, prop, value})
 }
 
-func (packet *packet) twoWayPing(dir byte, data int16) {
-	packet.marshal(struct {
-		PacketID  byte
-		Direction byte
-		Data      int16
-	}{packetTypeTwoWayPing, dir, data})
-}
-
-func (packet *packet) setInventoryOrder(order byte, block byte) {
-	packet.marshal(struct {
-		PacketID byte
-		Order    byte
-		BlockID  byte
-	}{packetTypeSetInventoryOrder, order, block})
+func (packet *packet) setInventoryOrder(order uint16, block uint16, extBlocks bool) {
+	packet.WriteByte(proto.PacketTypeSetInventoryOrder)
+	packet.BlockID(order, extBlocks)
+	packet.BlockID(block, extBlocks)
 }
 
+// levelStream splits the compressed level data into LevelDataChunk packets.
 type levelStream struct {
-	player  *Player
 	packet  packet
 	index   int
 	percent byte
+	chunks  [][]byte
 }
 
 func (stream *levelStream) reset() {
-	stream.packet.Reset()
-	stream.packet.Write([]byte{packetTypeLevelDataChunk, 0, 0})
+	stream.packet = packet{}
+	stream.packet.Write([]byte{proto.PacketTypeLevelDataChunk, 0, 0})
 	stream.index = 0
 }
 
@@ -752,7 +798,7 @@ func (stream *levelStream) send() {
 	buf := stream.packet.Bytes()
 	binary.BigEndian.PutUint16(buf[1:], uint16(stream.index))
 
-	stream.player.sendPacket(stream.packet)
+	stream.chunks = append(stream.chunks, stream.packet.Bytes())
 	stream.reset()
 }
 
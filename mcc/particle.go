@@ -0,0 +1,73 @@
+package mcc
+
+const (
+	ParticleCollideLiquid  = 1 << 0
+	ParticleCollideSolid   = 1 << 1
+	ParticleCollideLeaves  = 1 << 2
+	ParticleExpireOnGround = 1 << 7
+	ParticleCollideAll     = ParticleCollideLiquid | ParticleCollideSolid | ParticleCollideLeaves
+	ParticleCollideDefault = ParticleCollideSolid | ParticleCollideLeaves | ParticleExpireOnGround
+)
+
+// Particle describes a custom particle effect.
+// U1, V1, U2 and V2 specify the texture in particles.png. Sizes and distances
+// are specified in blocks, times in seconds.
+type Particle struct {
+	ID byte
+
+	U1, V1, U2, V2 byte
+	Tint           RGB
+	FrameCount     byte
+	ParticleCount  byte
+
+	Size              float64
+	SizeVariation     float64
+	Spread            float64
+	Speed             float64
+	Gravity           float64
+	Lifetime          float64
+	LifetimeVariation float64
+
+	CollideFlags byte
+	FullBright   bool
+}
+
+// DefineParticle registers particle with the server and sends it to all
+// players. A particle with the same ID is replaced.
+func (server *Server) DefineParticle(particle *Particle) {
+	server.particlesLock.Lock()
+	server.particles[particle.ID] = particle
+	server.particlesLock.Unlock()
+
+	server.ForEachPlayer(func(player *Player) {
+		player.sendParticle(particle)
+	})
+}
+
+// FindParticle returns the particle with the specified ID.
+func (server *Server) FindParticle(id byte) *Particle {
+	server.particlesLock.RLock()
+	defer server.particlesLock.RUnlock()
+	return server.particles[id]
+}
+
+// ForEachParticle calls fn for each particle.
+func (server *Server) ForEachParticle(fn func(*Particle)) {
+	server.particlesLock.RLock()
+	defer server.particlesLock.RUnlock()
+
+	for _, particle := range server.particles {
+		if particle != nil {
+			fn(particle)
+		}
+	}
+}
+
+// SpawnParticle spawns the particle effect with the specified ID at the
+// specified coordinates for all players in the level. The particles move
+// away from origin.
+func (level *Level) SpawnParticle(x, y, z float64, origin Vector3F, effectID byte) {
+	level.ForEachPlayer(func(player *Player) {
+		player.sendSpawnParticle(x, y, z, origin, effectID)
+	})
+}
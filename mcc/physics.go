@@ -51,10 +51,14 @@ func (queue *blockUpdateQueue) tick() (updates []int) {
 type WaterSimulator struct {
 	Level *Level
 	queue blockUpdateQueue
+
+	// Particle, if not nil, is the effect spawned when water turns lava
+	// into stone.
+	Particle *Particle
 }
 
 // Update implements Simulator.
-func (simulator *WaterSimulator) Update(block, old byte, index int) {
+func (simulator *WaterSimulator) Update(block, old uint16, index int) {
 	if block == BlockActiveWater || (block == BlockWater && block == old) {
 		simulator.queue.add(index, 5)
 	} else {
@@ -135,6 +139,7 @@ func (simulator *WaterSimulator) spread(x, y, z int) {
 
 	case BlockActiveLava, BlockLava:
 		level.SetBlock(x, y, z, BlockStone)
+		spawnSolidifyParticle(level, simulator.Particle, x, y, z)
 	}
 }
 
@@ -170,10 +175,14 @@ func (simulator *WaterSimulator) breakSponge(x, y, z int) {
 type LavaSimulator struct {
 	Level *Level
 	queue blockUpdateQueue
+
+	// Particle, if not nil, is the effect spawned when lava turns water
+	// into stone.
+	Particle *Particle
 }
 
 // Update implements Simulator.
-func (simulator *LavaSimulator) Update(block, old byte, index int) {
+func (simulator *LavaSimulator) Update(block, old uint16, index int) {
 	if block == BlockActiveLava || (block == BlockLava && block == old) {
 		simulator.queue.add(index, 30)
 	}
@@ -215,6 +224,14 @@ func (simulator *LavaSimulator) spread(x, y, z int) {
 
 	case BlockActiveWater, BlockWater:
 		level.SetBlock(x, y, z, BlockStone)
+		spawnSolidifyParticle(level, simulator.Particle, x, y, z)
+	}
+}
+
+func spawnSolidifyParticle(level *Level, particle *Particle, x, y, z int) {
+	if particle != nil {
+		cx, cy, cz := float64(x)+0.5, float64(y)+0.5, float64(z)+0.5
+		level.SpawnParticle(cx, cy+0.5, cz, Vector3F{cx, cy, cz}, particle.ID)
 	}
 }
 
@@ -225,7 +242,7 @@ type SandSimulator struct {
 }
 
 // Update implements Simulator.
-func (simulator *SandSimulator) Update(block, old byte, index int) {
+func (simulator *SandSimulator) Update(block, old uint16, index int) {
 	if block != BlockSand && block != BlockGravel {
 		return
 	}
@@ -2,17 +2,17 @@ package mcc
 
 import (
 	"bytes"
-	"compress/flate"
-	"compress/gzip"
-	"crypto/md5"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/AndreasGoulas/go-mcc/mcc/internal/proto"
 )
 
 const (
@@ -21,6 +21,46 @@ const (
 	stateGame   = 2
 )
 
+const (
+	// SendQueueSize is the number of outbound packets that can be pending
+	// for a player. Players whose queue overflows are kicked.
+	SendQueueSize = 1024
+
+	// writeBufferSize is the number of bytes that are buffered before they
+	// are written, even if the tick is not over yet.
+	writeBufferSize = 64 * 1024
+
+	flushTimeout = 5 * time.Second
+)
+
+const (
+	VelocityAdd = 0
+	VelocitySet = 1
+
+	// velocitySteps is the number of teleports used to approximate a
+	// change of velocity for clients without VelocityControl.
+	velocitySteps = 10
+)
+
+// simulatedVelocity is the velocity of a player without VelocityControl,
+// which is applied with one teleport per tick.
+type simulatedVelocity struct {
+	x, y, z  float64
+	steps    int
+	level    *Level
+	location Location
+}
+
+// HotbarSize is the number of slots in the hotbar.
+const HotbarSize = 9
+
+// DefaultHotbar is the hotbar that clients start with.
+var DefaultHotbar = [HotbarSize]uint16{
+	BlockStone, BlockCobblestone, BlockBrick,
+	BlockDirt, BlockWood, BlockLog,
+	BlockLeaves, BlockGrass, BlockSlab,
+}
+
 // Player represents a game client.
 type Player struct {
 	*Entity
@@ -31,25 +71,70 @@ type Player struct {
 	conn  net.Conn
 	state uint32
 
+	sendQueue  chan []byte
+	wake       chan struct{}
+	quit       chan struct{}
+	done       chan struct{}
+	overflowed uint32
+
+	blockLevel   *Level
+	blockIndices []int32
+	blockBlocks  []uint16
+	blockLock    sync.Mutex
+
 	cpe           [CpeCount]bool
 	remExtensions int
 	message       string
-	maxBlockID    byte
+	maxBlockID    uint16
 	cpeBlockLevel byte
-	heldBlock     byte
+	heldBlock     uint16
+
+	inventory     []uint16
+	hotbar        [HotbarSize]uint16
+	hotbarMask    uint16
+	inventoryLock sync.Mutex
+
+	spawns     map[string]Location
+	spawnsLock sync.Mutex
 
-	pingTicker *time.Ticker
 	pingBuffer pingBuffer
+
+	chatBucket  tokenBucket
+	blockBucket tokenBucket
+	moveBucket  tokenBucket
+
+	movement movementChecker
+
+	velocity     simulatedVelocity
+	velocityLock sync.Mutex
+
+	entityIDs entityTable
+	nameIDs   entityTable
+
+	recorder *Recorder
 }
 
 // NewPlayer returns a new Player.
 func NewPlayer(conn net.Conn, server *Server) *Player {
-	return &Player{
+	player := &Player{
 		Entity:    NewEntity("", server),
 		conn:      conn,
 		state:     stateClosed,
+		sendQueue: make(chan []byte, SendQueueSize),
+		wake:      make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
 		heldBlock: BlockAir,
+
+		chatBucket:  newTokenBucket(server.rateLimit(RateLimitChat)),
+		blockBucket: newTokenBucket(server.rateLimit(RateLimitBlock)),
+		moveBucket:  newTokenBucket(server.rateLimit(RateLimitMovement)),
+
+		movement: newMovementChecker(),
 	}
+
+	go player.writeLoop()
+	return player
 }
 
 // CanExecute implements CommandSender.
@@ -68,27 +153,27 @@ func (player *Player) HasExtension(extension int) bool {
 }
 
 // RemoteAddr returns the remote network address as a string.
+// IPv6 addresses are returned in canonical form without brackets.
 func (player *Player) RemoteAddr() string {
-	addr := player.conn.RemoteAddr()
-	host, _, _ := net.SplitHostPort(addr.String())
-	return host
+	return remoteIP(player.conn.RemoteAddr())
+}
+
+// QueueLength returns the number of packets waiting to be sent to the player.
+func (player *Player) QueueLength() int {
+	return len(player.sendQueue)
 }
 
 // Disconnect closes the remote connection.
+// Packets that are already queued are sent before the connection is closed.
 func (player *Player) Disconnect() {
-	if player.state == stateClosed {
+	state := atomic.SwapUint32(&player.state, stateClosed)
+	if state == stateClosed {
 		return
 	}
 
-	if player.pingTicker != nil {
-		player.pingTicker.Stop()
-	}
-
-	loggedIn := player.state == stateGame
-	atomic.StoreUint32(&player.state, stateClosed)
-	player.conn.Close()
+	close(player.quit)
 
-	if loggedIn {
+	if state == stateGame {
 		event := EventPlayerQuit{player}
 		player.server.FireEvent(EventTypePlayerQuit, &event)
 
@@ -112,35 +197,195 @@ func (player *Player) Kick(reason string) {
 // CanReach reports whether the player can reach the block at the specified
 // coordinates.
 func (player *Player) CanReach(x, y, z int) bool {
-	loc := player.location
+	loc := player.Location()
 	dx := math.Min(math.Abs(loc.X-float64(x)), math.Abs(loc.X-float64(x+1)))
 	dy := math.Min(math.Abs(loc.Y-float64(y)), math.Abs(loc.Y-float64(y+1)))
 	dz := math.Min(math.Abs(loc.Z-float64(z)), math.Abs(loc.Z-float64(z+1)))
-	dist := player.level.HackConfig.ReachDistance
+	dist := player.Level().HackConfig.ReachDistance
 	return dx*dx+dy*dy+dz*dz <= dist*dist
 }
 
 // HeldBlock returns the block that the player is holding.
 // If the player does not support the HeldBlock extension, the function returns
 // BlockAir.
-func (player *Player) HeldBlock() byte {
+func (player *Player) HeldBlock() uint16 {
 	return player.heldBlock
 }
 
 // SetHeldBlock changes the block that the player is holding.
 // lock controls whether the player can change the held block.
-func (player *Player) SetHeldBlock(block byte, lock bool) {
-	level := player.level
-	if player.state == stateGame && player.cpe[CpeHeldBlock] && level != nil {
+func (player *Player) SetHeldBlock(block uint16, lock bool) {
+	level := player.Level()
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeHeldBlock] && level != nil {
 		var packet packet
-		packet.holdThis(player.convertBlock(block, level), lock)
+		packet.holdThis(player.convertBlock(block, level), lock, player.cpe[CpeExtendedBlocks])
 		player.sendPacket(packet)
 	}
 }
 
+// SetVelocity changes the velocity of the player. The velocity is specified
+// in blocks per tick, and mode is either VelocityAdd or VelocitySet.
+// If the player does not support the VelocityControl extension, the motion
+// is approximated with a short series of teleports.
+func (player *Player) SetVelocity(x, y, z float64, mode byte) {
+	if atomic.LoadUint32(&player.state) != stateGame || player.Level() == nil {
+		return
+	}
+
+	if player.cpe[CpeVelocityControl] {
+		player.allowMovement(velocityGrace)
+		var packet packet
+		packet.velocityControl(x, y, z, mode)
+		player.sendPacket(packet)
+	} else {
+		player.velocityLock.Lock()
+		velocity := &player.velocity
+		if mode == VelocitySet || velocity.steps == 0 || velocity.level != player.Level() {
+			*velocity = simulatedVelocity{x, y, z, velocitySteps, player.Level(), player.Location()}
+		} else {
+			velocity.x += x
+			velocity.y += y
+			velocity.z += z
+			velocity.steps = velocitySteps
+		}
+		player.velocityLock.Unlock()
+	}
+}
+
+// Push adds the specified velocity to the velocity of the player.
+func (player *Player) Push(x, y, z float64) {
+	player.SetVelocity(x, y, z, VelocityAdd)
+}
+
+// updateVelocity advances the simulated velocity of the player by one tick.
+func (player *Player) updateVelocity() {
+	player.velocityLock.Lock()
+	velocity := &player.velocity
+	if velocity.steps == 0 {
+		player.velocityLock.Unlock()
+		return
+	}
+
+	level := velocity.level
+	next := player.Location()
+	next.X = velocity.location.X + velocity.x
+	next.Y = velocity.location.Y + velocity.y
+	next.Z = velocity.location.Z + velocity.z
+	if atomic.LoadUint32(&player.state) != stateGame || player.Level() != level ||
+		!player.canOccupy(level, next) {
+		velocity.steps = 0
+		player.velocityLock.Unlock()
+		return
+	}
+
+	velocity.steps--
+	velocity.location = next
+	velocity.x *= 0.91
+	velocity.y = (velocity.y - 0.08) * 0.98
+	velocity.z *= 0.91
+	player.velocityLock.Unlock()
+
+	player.Teleport(next)
+}
+
+// canOccupy reports whether the player can stand at location without being
+// stuck inside a solid block.
+func (player *Player) canOccupy(level *Level, location Location) bool {
+	x, z := int(math.Floor(location.X)), int(math.Floor(location.Z))
+	feet := location.Y - playerEyeHeight
+	for y := int(math.Floor(feet)); y <= int(math.Floor(feet+playerHeight)); y++ {
+		if !level.InBounds(x, y, z) {
+			continue
+		}
+
+		if level.CollideMode(level.GetBlock(x, y, z)) == CollideModeSolid {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SendPluginMessage sends a plugin message to the player on the specified
+// channel. data is padded with zeros or truncated to 64 bytes.
+func (player *Player) SendPluginMessage(channel byte, data []byte) {
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpePluginMessages] {
+		var packet packet
+		packet.PluginMessage(channel, data)
+		player.sendPacket(packet)
+	}
+}
+
+// SetHotbar places block in the specified slot of the hotbar of the player.
+// The block is kept when the player changes level, until ResetHotbar is
+// called.
+func (player *Player) SetHotbar(slot byte, block uint16) {
+	if slot >= HotbarSize {
+		return
+	}
+
+	player.inventoryLock.Lock()
+	player.hotbar[slot] = block
+	player.hotbarMask |= 1 << slot
+	player.inventoryLock.Unlock()
+
+	player.sendHotbarSlot(player.Level(), slot, block)
+}
+
+// ResetHotbar restores the slots of the hotbar that were changed with
+// SetHotbar to DefaultHotbar.
+func (player *Player) ResetHotbar() {
+	player.inventoryLock.Lock()
+	mask := player.hotbarMask
+	player.hotbarMask = 0
+	player.inventoryLock.Unlock()
+
+	for slot := byte(0); slot < HotbarSize; slot++ {
+		if mask&(1<<slot) != 0 {
+			player.sendHotbarSlot(player.Level(), slot, DefaultHotbar[slot])
+		}
+	}
+}
+
+// SetInventory overrides the inventory order of the level for the player.
+// order[block] is the position of block in the inventory, or 0 if the block
+// is hidden. The order is kept when the player changes level, until
+// ResetInventory is called.
+func (player *Player) SetInventory(order []uint16) {
+	level := player.Level()
+	if level != nil {
+		player.resetInventory(level)
+	}
+
+	player.inventoryLock.Lock()
+	player.inventory = make([]uint16, len(order))
+	copy(player.inventory, order)
+	player.inventoryLock.Unlock()
+
+	if level != nil {
+		player.sendInventory(level)
+	}
+}
+
+// ResetInventory restores the inventory order of the current level.
+func (player *Player) ResetInventory() {
+	level := player.Level()
+	if level != nil {
+		player.resetInventory(level)
+	}
+
+	player.inventoryLock.Lock()
+	player.inventory = nil
+	player.inventoryLock.Unlock()
+
+	if level != nil {
+		player.sendInventory(level)
+	}
+}
+
 // SetSelection marks a cuboid selection.
 func (player *Player) SetSelection(id byte, label string, box AABB, color RGBA) {
-	if player.state == stateGame && player.cpe[CpeSelectionCuboid] {
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeSelectionCuboid] {
 		var packet packet
 		packet.makeSelection(id, label, box, color)
 		player.sendPacket(packet)
@@ -149,7 +394,7 @@ func (player *Player) SetSelection(id byte, label string, box AABB, color RGBA)
 
 // ResetSelection resets the selection with the specified ID.
 func (player *Player) ResetSelection(id byte) {
-	if player.state == stateGame && player.cpe[CpeSelectionCuboid] {
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeSelectionCuboid] {
 		var packet packet
 		packet.removeSelection(id)
 		player.sendPacket(packet)
@@ -213,7 +458,7 @@ func (player *Player) SendMessageExt(msgType int, message string) {
 	var packet packet
 	message = player.convertMessage(message)
 	for _, line := range WordWrap(message, 64) {
-		packet.message(msgType, line)
+		packet.Message(byte(msgType), line)
 	}
 
 	player.sendPacket(packet)
@@ -222,32 +467,214 @@ func (player *Player) SendMessageExt(msgType int, message string) {
 // SetSpawn sets the spawn location of the player to the current player
 // location.
 func (player *Player) SetSpawn() {
-	player.sendSpawn(player.Entity)
+	player.SetSpawnLocation(player.Location())
+}
+
+// SetSpawnLocation sets the personal spawn location of the player in the
+// current level. Respawns of the player use this location instead of the
+// spawn location of the level.
+func (player *Player) SetSpawnLocation(location Location) {
+	level := player.Level()
+	if level == nil {
+		return
+	}
+
+	player.spawnsLock.Lock()
+	if player.spawns == nil {
+		player.spawns = make(map[string]Location)
+	}
+	player.spawns[level.Name] = location
+	player.spawnsLock.Unlock()
+
+	player.sendSpawnpoint(location)
+}
+
+// ResetSpawnLocation removes the personal spawn location of the player in
+// the current level.
+func (player *Player) ResetSpawnLocation() {
+	level := player.Level()
+	if level == nil {
+		return
+	}
+
+	player.spawnsLock.Lock()
+	delete(player.spawns, level.Name)
+	player.spawnsLock.Unlock()
+
+	player.sendSpawnpoint(level.Spawn)
+}
+
+// PersonalSpawn returns the personal spawn location of the player in level.
+// ok is false if the player has no personal spawn location in level.
+func (player *Player) PersonalSpawn(level *Level) (location Location, ok bool) {
+	player.spawnsLock.Lock()
+	defer player.spawnsLock.Unlock()
+	location, ok = player.spawns[level.Name]
+	return
+}
+
+// SpawnLocation returns the location where the player respawns in the
+// current level.
+func (player *Player) SpawnLocation() Location {
+	level := player.Level()
+	if level == nil {
+		return Location{}
+	}
+
+	if location, ok := player.PersonalSpawn(level); ok {
+		return location
+	}
+
+	return level.Spawn
+}
+
+func (player *Player) sendSpawnpoint(location Location) {
+	if atomic.LoadUint32(&player.state) != stateGame {
+		return
+	}
+
+	if player.cpe[CpeSetSpawnpoint] {
+		var packet packet
+		packet.setSpawnpoint(location, player.cpe[CpeExtEntityPositions])
+		player.sendPacket(packet)
+	} else if location == player.Location() {
+		// Spawning the player again sets the spawn location of clients
+		// to their current location.
+		player.sendSpawn(player.Entity)
+	}
 }
 
 func (player *Player) sendPacket(packet packet) {
-	if player.state == stateClosed {
+	if atomic.LoadUint32(&player.state) == stateClosed {
+		return
+	}
+
+	select {
+	case player.sendQueue <- packet.Bytes():
+	default:
+		player.overflowQueue()
+	}
+}
+
+// sendPacketWait is like sendPacket, but waits for space in the queue instead
+// of kicking the player when the queue is full.
+func (player *Player) sendPacketWait(packet packet) {
+	player.sendDataWait(packet.Bytes())
+}
+
+// sendDataWait queues data that is already encoded, such as cached level
+// data, which must not be modified afterwards.
+func (player *Player) sendDataWait(data []byte) {
+	if atomic.LoadUint32(&player.state) == stateClosed {
 		return
 	}
 
-	_, err := packet.WriteTo(player.conn)
-	if err == io.EOF {
-		player.Disconnect()
+	select {
+	case player.sendQueue <- data:
+	case <-player.quit:
 	}
 }
 
-func (player *Player) convertBlock(block byte, level *Level) byte {
-	if !player.cpe[CpeBlockDefinitions] {
-		if level.BlockDefs != nil {
-			if def := level.BlockDefs[block]; def != nil {
-				block = def.Fallback
+func (player *Player) record(direction byte, data []byte) {
+	if player.recorder != nil {
+		if err := player.recorder.Record(direction, data); err != nil {
+			log.Printf("record: %s\n", err)
+			player.recorder.Close()
+		}
+	}
+}
+
+func (player *Player) overflowQueue() {
+	if !atomic.CompareAndSwapUint32(&player.overflowed, 0, 1) {
+		return
+	}
+
+	for len(player.sendQueue) > 0 {
+		select {
+		case <-player.sendQueue:
+		default:
+		}
+	}
+
+	player.Kick("Too many pending packets!")
+}
+
+// flush sends the block changes of the current tick and wakes up the write
+// loop, which writes all packets queued so far.
+func (player *Player) flush() {
+	player.flushBlockChanges()
+	select {
+	case player.wake <- struct{}{}:
+	default:
+	}
+}
+
+// writeLoop writes the queued packets to the connection. Packets are
+// buffered until the end of the tick, except during login.
+func (player *Player) writeLoop() {
+	var buffer bytes.Buffer
+	for {
+		select {
+		case data := <-player.sendQueue:
+			player.record(RecordOutbound, data)
+			buffer.Write(data)
+			if buffer.Len() < writeBufferSize && atomic.LoadUint32(&player.state) == stateGame {
+				continue
+			}
+
+		case <-player.wake:
+
+		case <-player.quit:
+			player.conn.SetWriteDeadline(time.Now().Add(flushTimeout))
+			player.flushQueue(&buffer)
+			player.conn.Close()
+			if player.recorder != nil {
+				player.recorder.Close()
 			}
+			close(player.done)
+			return
 		}
 
-		if block > BlockMaxCPE {
-			return BlockAir
+		if buffer.Len() > 0 {
+			if _, err := player.conn.Write(buffer.Bytes()); err != nil {
+				player.Disconnect()
+			}
+			buffer.Reset()
 		}
 	}
+}
+
+func (player *Player) flushQueue(buffer *bytes.Buffer) {
+	for {
+		select {
+		case data := <-player.sendQueue:
+			player.record(RecordOutbound, data)
+			buffer.Write(data)
+
+		default:
+			// Empty writes still wait for the client on some connections.
+			if buffer.Len() > 0 {
+				player.conn.Write(buffer.Bytes())
+			}
+			buffer.Reset()
+			return
+		}
+	}
+}
+
+func (player *Player) convertBlock(block uint16, level *Level) uint16 {
+	if !player.cpe[CpeBlockDefinitions] ||
+		(block > BlockMaxDefinitions && !player.cpe[CpeExtendedBlocks]) {
+		if def := level.BlockDef(block); def != nil {
+			block = def.Fallback
+		}
+	}
+
+	if !player.cpe[CpeBlockDefinitions] && block > BlockMaxCPE {
+		return BlockAir
+	} else if !player.cpe[CpeExtendedBlocks] && block > BlockMaxDefinitions {
+		return BlockAir
+	}
 
 	if player.cpeBlockLevel < 1 {
 		return FallbackBlock(block)
@@ -275,69 +702,84 @@ func (player *Player) sendMOTD(level *Level) {
 }
 
 func (player *Player) sendLevel(level *Level) {
-	if player.state != stateGame {
+	if atomic.LoadUint32(&player.state) != stateGame {
 		return
 	}
 
 	player.sendMOTD(level)
 
-	var conv [BlockMax]byte
-	for i := byte(0); i < BlockMax; i++ {
-		conv[i] = player.convertBlock(i, level)
+	key := levelCacheKey{
+		fastMap:   player.cpe[CpeFastMap],
+		extBlocks: player.cpe[CpeExtendedBlocks],
+	}
+	for i := range key.conv {
+		key.conv[i] = player.convertBlock(uint16(i), level)
 	}
 
-	stream := levelStream{player: player}
-	stream.reset()
-	if player.cpe[CpeFastMap] {
-		var packet packet
-		packet.levelInitializeExt(level.Size())
-		player.sendPacket(packet)
-
-		writer, _ := flate.NewWriter(&stream, -1)
-		for i, block := range level.Blocks {
-			stream.percent = byte(i * 100 / len(level.Blocks))
-			writer.Write([]byte{conv[block]})
-		}
-		writer.Close()
+	var packet0 packet
+	if key.fastMap {
+		packet0.levelInitializeExt(level.Size())
 	} else {
-		var packet packet
-		packet.levelInitialize()
-		player.sendPacket(packet)
+		packet0.levelInitialize()
+	}
+	player.sendPacket(packet0)
 
-		writer := gzip.NewWriter(&stream)
-		binary.Write(writer, binary.BigEndian, int32(level.Size()))
-		for i, block := range level.Blocks {
-			stream.percent = byte(i * 100 / len(level.Blocks))
-			writer.Write([]byte{conv[block]})
-		}
-		writer.Close()
+	for _, chunk := range level.levelData(&key) {
+		player.sendDataWait(chunk)
 	}
-	stream.Close()
 
 	player.sendBlockDefinitions(level)
 	player.sendInventory(level)
+	player.sendHotbar(level)
 	player.sendEnvConfig(level, EnvPropAll)
 	player.sendHackConfig(level)
 
 	player.SendPermissions()
 
-	var packet packet
-	packet.levelFinalize(level.Width, level.Height, level.Length)
-	player.sendPacket(packet)
+	var packet1 packet
+	packet1.levelFinalize(level.Width, level.Height, level.Length)
+	player.sendPacket(packet1)
+}
+
+// EntityID returns the ID that identifies entity to the player. It returns
+// false if the entity is not visible to the player.
+func (player *Player) EntityID(entity *Entity) (byte, bool) {
+	if entity == player.Entity {
+		return 0xff, true
+	}
+
+	return player.entityIDs.id(entity)
+}
+
+// FindEntityByID returns the entity that the player knows by the specified
+// ID.
+func (player *Player) FindEntityByID(id byte) *Entity {
+	if id == 0xff {
+		return player.Entity
+	}
+
+	return player.entityIDs.entity(id)
 }
 
 func (player *Player) sendSpawn(entity *Entity) {
-	if player.state != stateGame {
+	if atomic.LoadUint32(&player.state) != stateGame {
 		return
 	}
 
+	id := byte(0xff)
+	if entity != player.Entity {
+		var ok bool
+		if id, ok = player.entityIDs.add(entity); !ok {
+			return
+		}
+	}
+
 	var packet packet
-	self := entity.id == player.id
 	extPos := player.cpe[CpeExtEntityPositions]
 	if player.cpe[CpeExtPlayerList] {
-		packet.extAddEntity2(entity, self, extPos)
+		packet.extAddEntity2(entity, id, extPos)
 	} else {
-		packet.addEntity(entity, self, extPos)
+		packet.addEntity(entity, id, extPos)
 	}
 
 	player.sendPacket(packet)
@@ -350,60 +792,209 @@ func (player *Player) sendSpawn(entity *Entity) {
 }
 
 func (player *Player) sendDespawn(entity *Entity) {
-	if player.state == stateGame {
+	id := byte(0xff)
+	if entity != player.Entity {
+		var ok bool
+		if id, ok = player.entityIDs.remove(entity); !ok {
+			return
+		}
+	}
+
+	if atomic.LoadUint32(&player.state) == stateGame {
 		var packet packet
-		packet.removeEntity(entity, entity.id == player.id)
+		packet.removeEntity(id)
 		player.sendPacket(packet)
 	}
 }
 
 func (player *Player) spawnLevel(level *Level) {
+	player.allowMovement(movementGrace)
 	player.sendLevel(level)
 	player.sendSpawn(player.Entity)
+	if location, ok := player.PersonalSpawn(level); ok {
+		player.sendSpawnpoint(location)
+	}
 	level.ForEachEntity(func(other *Entity) {
-		player.sendSpawn(other)
+		if other != player.Entity && player.inView(other, level) {
+			player.sendSpawn(other)
+		}
 	})
 }
 
 func (player *Player) despawnLevel(level *Level) {
+	player.resetBlockChanges()
 	player.resetBlockDefinitions(level)
 	player.resetInventory(level)
 	player.sendDespawn(player.Entity)
 	level.ForEachEntity(func(other *Entity) {
-		player.sendDespawn(other)
+		if other != player.Entity {
+			player.sendDespawn(other)
+		}
+	})
+	player.entityIDs.clear()
+}
+
+// inView reports whether entity is within the view distance of the player
+// in level.
+func (player *Player) inView(entity *Entity, level *Level) bool {
+	distance := level.viewDistance()
+	if distance == 0 || entity == player.Entity {
+		return true
+	}
+
+	location, own := entity.Location(), player.Location()
+	dx := location.X - own.X
+	dy := location.Y - own.Y
+	dz := location.Z - own.Z
+	return dx*dx+dy*dy+dz*dz <= distance*distance
+}
+
+// updateView spawns the entities in level that have come into view of the
+// player, and despawns the ones that have left it.
+func (player *Player) updateView(level *Level) {
+	if level.viewDistance() == 0 {
+		return
+	}
+
+	level.ForEachEntity(func(other *Entity) {
+		if other == player.Entity {
+			return
+		}
+
+		_, known := player.entityIDs.id(other)
+		visible := player.inView(other, level)
+		switch {
+		case known && !visible:
+			player.sendDespawn(other)
+		case !known && visible && !other.moved():
+			// Entities that have moved are spawned by their own update,
+			// since a relative movement would be applied twice otherwise.
+			player.sendSpawn(other)
+		}
 	})
 }
 
 func (player *Player) sendTeleport(entity *Entity) {
-	if player.state == stateGame {
+	if atomic.LoadUint32(&player.state) != stateGame {
+		return
+	}
+
+	if id, ok := player.EntityID(entity); ok {
 		var packet packet
 		extPos := player.cpe[CpeExtEntityPositions]
-		packet.teleport(entity, entity.id == player.id, extPos)
+		packet.teleport(id, entity.Location(), extPos)
 		player.sendPacket(packet)
 	}
 }
 
-func (player *Player) sendBlockChange(x, y, z int, block byte) {
-	level := player.level
-	if player.state == stateGame && level != nil {
-		var packet packet
-		packet.setBlock(x, y, z, player.convertBlock(block, level))
-		player.sendPacket(packet)
+func (player *Player) sendTeleportExt(entity *Entity, flags byte, last Location) {
+	if atomic.LoadUint32(&player.state) != stateGame {
+		return
+	}
+
+	if !player.cpe[CpeExtEntityTeleport] {
+		player.sendTeleport(entity)
+		return
+	}
+
+	id, ok := player.EntityID(entity)
+	if !ok {
+		return
 	}
+
+	var packet packet
+	extFlags, location := extTeleport(flags, entity.Location(), last)
+	extPos := player.cpe[CpeExtEntityPositions]
+	packet.extEntityTeleport(id, extFlags, location, extPos)
+	player.sendPacket(packet)
+}
+
+func (player *Player) sendBlockChange(x, y, z int, block uint16) {
+	level := player.Level()
+	if atomic.LoadUint32(&player.state) != stateGame || level == nil {
+		return
+	}
+
+	block = player.convertBlock(block, level)
+	if player.cpe[CpeBulkBlockUpdate] {
+		player.queueBlockChange(level, int32(level.Index(x, y, z)), block)
+		return
+	}
+
+	var packet packet
+	packet.setBlock(x, y, z, block, player.cpe[CpeExtendedBlocks])
+	player.sendPacket(packet)
+}
+
+// queueBlockChange queues a converted block change in level, to be sent
+// with the other block changes of the tick.
+func (player *Player) queueBlockChange(level *Level, index int32, block uint16) {
+	player.blockLock.Lock()
+	if player.blockLevel != level {
+		player.blockLevel = level
+		player.blockIndices = player.blockIndices[:0]
+		player.blockBlocks = player.blockBlocks[:0]
+	}
+
+	player.blockIndices = append(player.blockIndices, index)
+	player.blockBlocks = append(player.blockBlocks, block)
+	player.blockLock.Unlock()
+}
+
+// flushBlockChanges sends the queued block changes, merged into
+// BulkBlockUpdate packets.
+func (player *Player) flushBlockChanges() {
+	player.blockLock.Lock()
+	defer player.blockLock.Unlock()
+
+	level := player.blockLevel
+	indices, blocks := player.blockIndices, player.blockBlocks
+	player.blockIndices = player.blockIndices[:0]
+	player.blockBlocks = player.blockBlocks[:0]
+	if len(indices) == 0 || level != player.Level() {
+		return
+	}
+
+	var packet packet
+	extBlocks := player.cpe[CpeExtendedBlocks]
+	if len(indices) == 1 {
+		x, y, z := level.Position(int(indices[0]))
+		packet.setBlock(x, y, z, blocks[0], extBlocks)
+	} else {
+		for i := 0; i < len(indices); i += 256 {
+			end := i + 256
+			if end > len(indices) {
+				end = len(indices)
+			}
+
+			packet.bulkBlockUpdate(indices[i:end], blocks[i:end], extBlocks)
+		}
+	}
+
+	player.sendPacket(packet)
+}
+
+// resetBlockChanges discards the queued block changes.
+func (player *Player) resetBlockChanges() {
+	player.blockLock.Lock()
+	player.blockLevel = nil
+	player.blockIndices = player.blockIndices[:0]
+	player.blockBlocks = player.blockBlocks[:0]
+	player.blockLock.Unlock()
 }
 
 func (player *Player) sendCPE() {
 	var packet packet
-	packet.extInfo()
+	packet.ExtInfo(ServerSoftware, len(Extensions))
 	for _, extension := range Extensions {
-		packet.extEntry(&extension)
+		packet.ExtEntry(extension.Name, extension.Version)
 	}
 
 	player.sendPacket(packet)
 }
 
 func (player *Player) sendHotkeys() {
-	if player.state == stateGame && player.cpe[CpeTextHotKey] {
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeTextHotKey] {
 		var packet packet
 		for _, desc := range player.server.Hotkeys {
 			packet.setTextHotKey(&desc)
@@ -414,7 +1005,7 @@ func (player *Player) sendHotkeys() {
 }
 
 func (player *Player) sendTextColors() {
-	if player.state == stateGame && player.cpe[CpeTextColors] {
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeTextColors] {
 		var packet packet
 		for _, desc := range player.server.Colors {
 			packet.setTextColor(&desc)
@@ -425,72 +1016,140 @@ func (player *Player) sendTextColors() {
 }
 
 func (player *Player) sendAddPlayerList(entity *Entity) {
-	if player.state == stateGame && player.cpe[CpeExtPlayerList] {
-		var packet packet
-		packet.extAddPlayerName(entity, entity.id == player.id)
-		player.sendPacket(packet)
+	if atomic.LoadUint32(&player.state) != stateGame || !player.cpe[CpeExtPlayerList] {
+		return
+	}
+
+	id := byte(0xff)
+	if entity != player.Entity {
+		var ok bool
+		if id, ok = player.nameIDs.add(entity); !ok {
+			return
+		}
 	}
+
+	var packet packet
+	packet.extAddPlayerName(entity, id)
+	player.sendPacket(packet)
 }
 
 func (player *Player) sendRemovePlayerList(entity *Entity) {
-	if player.state == stateGame && player.cpe[CpeExtPlayerList] {
+	id := byte(0xff)
+	if entity != player.Entity {
+		var ok bool
+		if id, ok = player.nameIDs.remove(entity); !ok {
+			return
+		}
+	}
+
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeExtPlayerList] {
 		var packet packet
-		packet.extRemovePlayerName(entity, entity.id == player.id)
+		packet.extRemovePlayerName(id)
 		player.sendPacket(packet)
 	}
 }
 
 func (player *Player) sendChangeModel(entity *Entity) {
-	if player.state == stateGame && player.cpe[CpeChangeModel] {
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeChangeModel] {
+		model := entity.Model
+		if !player.cpe[CpeCustomModels] {
+			if custom := player.server.FindModel(model); custom != nil {
+				model = custom.Fallback
+			}
+		}
+
+		if id, ok := player.EntityID(entity); ok {
+			var packet packet
+			packet.changeModel(id, model)
+			player.sendPacket(packet)
+		}
+	}
+}
+
+func (player *Player) sendParticle(particle *Particle) {
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeCustomParticles] {
 		var packet packet
-		packet.changeModel(entity, entity.id == player.id)
+		packet.defineEffect(particle)
+		player.sendPacket(packet)
+	}
+}
+
+func (player *Player) sendSpawnParticle(x, y, z float64, origin Vector3F, effectID byte) {
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeCustomParticles] {
+		var packet packet
+		packet.spawnEffect(x, y, z, origin, effectID)
+		player.sendPacket(packet)
+	}
+}
+
+func (player *Player) sendModel(model *Model) {
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeCustomModels] {
+		var packet packet
+		packet.defineModel(model)
+		for i := range model.Parts {
+			packet.defineModelPart(model, &model.Parts[i])
+		}
+
+		player.sendPacket(packet)
+	}
+}
+
+func (player *Player) sendUndefineModel(model *Model) {
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeCustomModels] {
+		var packet packet
+		packet.undefineModel(model)
 		player.sendPacket(packet)
 	}
 }
 
 func (player *Player) sendEntityProps(entity *Entity, mask uint32) {
-	if player.state != stateGame || !player.cpe[CpeEntityProperty] {
+	if atomic.LoadUint32(&player.state) != stateGame || !player.cpe[CpeEntityProperty] {
+		return
+	}
+
+	id, ok := player.EntityID(entity)
+	if !ok {
 		return
 	}
 
 	var packet packet
 	props := entity.Props
-	self := entity.id == player.id
 	if mask&EntityPropRotX != 0 {
-		packet.entityProperty(entity, self, 0, int32(props.RotX))
+		packet.entityProperty(id, 0, int32(props.RotX))
 	}
 	if mask&EntityPropRotY != 0 {
-		packet.entityProperty(entity, self, 1, int32(props.RotY))
+		packet.entityProperty(id, 1, int32(props.RotY))
 	}
 	if mask&EntityPropRotZ != 0 {
-		packet.entityProperty(entity, self, 2, int32(props.RotZ))
+		packet.entityProperty(id, 2, int32(props.RotZ))
 	}
 	if mask&EntityPropScaleX != 0 {
-		packet.entityProperty(entity, self, 3, int32(1000*props.ScaleX))
+		packet.entityProperty(id, 3, int32(1000*props.ScaleX))
 	}
 	if mask&EntityPropScaleY != 0 {
-		packet.entityProperty(entity, self, 4, int32(1000*props.ScaleY))
+		packet.entityProperty(id, 4, int32(1000*props.ScaleY))
 	}
 	if mask&EntityPropScaleZ != 0 {
-		packet.entityProperty(entity, self, 5, int32(1000*props.ScaleZ))
+		packet.entityProperty(id, 5, int32(1000*props.ScaleZ))
 	}
 
 	player.sendPacket(packet)
 }
 
 func (player *Player) sendBlockDefinitions(level *Level) {
-	if player.state != stateGame || !player.cpe[CpeBlockDefinitions] {
+	if atomic.LoadUint32(&player.state) != stateGame || !player.cpe[CpeBlockDefinitions] {
 		return
 	}
 
 	var packet packet
 	extTex := player.cpe[CpeExtendedTextures]
+	extBlocks := player.cpe[CpeExtendedBlocks]
 	for id, def := range level.BlockDefs {
-		if def != nil {
+		if def != nil && uint16(id) <= player.maxBlockID {
 			if player.cpe[CpeBlockDefinitionsExt] && def.Shape != 0 {
-				packet.defineBlock(byte(id), def, true, extTex)
+				packet.defineBlock(uint16(id), def, true, extTex, extBlocks)
 			} else {
-				packet.defineBlock(byte(id), def, false, extTex)
+				packet.defineBlock(uint16(id), def, false, extTex, extBlocks)
 			}
 		}
 	}
@@ -499,25 +1158,40 @@ func (player *Player) sendBlockDefinitions(level *Level) {
 }
 
 func (player *Player) resetBlockDefinitions(level *Level) {
-	if player.state != stateGame || !player.cpe[CpeBlockDefinitions] {
+	if atomic.LoadUint32(&player.state) != stateGame || !player.cpe[CpeBlockDefinitions] {
 		return
 	}
 
 	var packet packet
 	for id, def := range level.BlockDefs {
-		if def != nil {
-			packet.removeBlockDefinition(byte(id))
+		if def != nil && uint16(id) <= player.maxBlockID {
+			packet.removeBlockDefinition(uint16(id), player.cpe[CpeExtendedBlocks])
 		}
 	}
 
 	player.sendPacket(packet)
 }
 
+// inventoryOrder returns the inventory order that applies to the player in
+// level.
+func (player *Player) inventoryOrder(level *Level) []uint16 {
+	player.inventoryLock.Lock()
+	defer player.inventoryLock.Unlock()
+
+	if player.inventory != nil {
+		return player.inventory
+	}
+
+	return level.Inventory
+}
+
 func (player *Player) sendInventory(level *Level) {
-	if player.state == stateGame && player.cpe[CpeInventoryOrder] {
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeInventoryOrder] {
 		var packet packet
-		for id, order := range level.Inventory {
-			packet.setInventoryOrder(order, byte(id))
+		for id, order := range player.inventoryOrder(level) {
+			if uint16(id) <= player.maxBlockID && order <= player.maxBlockID {
+				packet.setInventoryOrder(order, uint16(id), player.cpe[CpeExtendedBlocks])
+			}
 		}
 
 		player.sendPacket(packet)
@@ -525,18 +1199,40 @@ func (player *Player) sendInventory(level *Level) {
 }
 
 func (player *Player) resetInventory(level *Level) {
-	if player.state == stateGame && player.cpe[CpeInventoryOrder] {
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeInventoryOrder] {
 		var packet packet
-		for id := range level.Inventory {
-			packet.setInventoryOrder(byte(id), byte(id))
+		for id := range player.inventoryOrder(level) {
+			if uint16(id) <= player.maxBlockID {
+				packet.setInventoryOrder(uint16(id), uint16(id), player.cpe[CpeExtendedBlocks])
+			}
 		}
 
 		player.sendPacket(packet)
 	}
 }
 
+func (player *Player) sendHotbarSlot(level *Level, slot byte, block uint16) {
+	if atomic.LoadUint32(&player.state) == stateGame && player.cpe[CpeSetHotbar] && level != nil {
+		var packet packet
+		packet.setHotbar(slot, player.convertBlock(block, level), player.cpe[CpeExtendedBlocks])
+		player.sendPacket(packet)
+	}
+}
+
+func (player *Player) sendHotbar(level *Level) {
+	player.inventoryLock.Lock()
+	hotbar, mask := player.hotbar, player.hotbarMask
+	player.inventoryLock.Unlock()
+
+	for slot := byte(0); slot < HotbarSize; slot++ {
+		if mask&(1<<slot) != 0 {
+			player.sendHotbarSlot(level, slot, hotbar[slot])
+		}
+	}
+}
+
 func (player *Player) sendEnvConfig(level *Level, mask uint32) {
-	if player.state != stateGame {
+	if atomic.LoadUint32(&player.state) != stateGame {
 		return
 	}
 
@@ -612,7 +1308,7 @@ func (player *Player) sendEnvConfig(level *Level, mask uint32) {
 }
 
 func (player *Player) sendHackConfig(level *Level) {
-	if player.state != stateGame {
+	if atomic.LoadUint32(&player.state) != stateGame {
 		return
 	}
 
@@ -630,7 +1326,7 @@ func (player *Player) sendHackConfig(level *Level) {
 
 // SendPermissions sends the block permissions to the player.
 func (player *Player) SendPermissions() {
-	if player.state != stateGame {
+	if atomic.LoadUint32(&player.state) != stateGame {
 		return
 	}
 
@@ -642,8 +1338,8 @@ func (player *Player) SendPermissions() {
 	var packet packet
 	packet.updateUserType(rank.CanPlace[BlockBedrock])
 	if player.cpe[CpeBlockPermissions] {
-		for i := 0; i < BlockCount; i++ {
-			packet.setBlockPermission(byte(i), rank.CanPlace[i], rank.CanBreak[i])
+		for i := 0; i <= int(player.maxBlockID); i++ {
+			packet.setBlockPermission(uint16(i), rank.CanPlace[i], rank.CanBreak[i], player.cpe[CpeExtendedBlocks])
 		}
 	}
 
@@ -652,46 +1348,37 @@ func (player *Player) SendPermissions() {
 
 func (player *Player) handle() {
 	buffer := make([]byte, 256)
+	loginDeadline := time.Now().Add(player.server.loginTimeout())
+	idleTimeout := player.server.idleTimeout()
 	atomic.StoreUint32(&player.state, stateLogin)
-	for player.state != stateClosed {
+	for atomic.LoadUint32(&player.state) != stateClosed {
+		if atomic.LoadUint32(&player.state) == stateLogin {
+			player.conn.SetReadDeadline(loginDeadline)
+		} else {
+			player.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+
 		buffer = buffer[:1]
 		_, err := io.ReadFull(player.conn, buffer)
 		if err != nil {
-			player.Disconnect()
+			player.handleReadError(err)
 			return
 		}
 
 		id := buffer[0]
 		var size int
-		switch player.state {
-		case stateLogin:
-			switch id {
-			case packetTypeIdentification:
-				size = 131
-			case packetTypeExtInfo:
-				size = 67
-			case packetTypeExtEntry:
-				size = 69
-			case packetTypeCustomBlockSupportLevel:
-				size = 2
+		switch id {
+		case proto.PacketTypeIdentification,
+			proto.PacketTypeExtInfo,
+			proto.PacketTypeExtEntry,
+			proto.PacketTypeCustomBlockSupportLevel:
+			if atomic.LoadUint32(&player.state) == stateLogin {
+				size = proto.ClientPacketSize(id, player.extensions())
 			}
 
-		case stateGame:
-			switch id {
-			case packetTypeSetBlockClient:
-				size = 9
-			case packetTypePlayerTeleport:
-				if player.cpe[CpeExtEntityPositions] {
-					size = 16
-				} else {
-					size = 10
-				}
-			case packetTypeMessage:
-				size = 66
-			case packetTypePlayerClicked:
-				size = 15
-			case packetTypeTwoWayPing:
-				size = 4
+		default:
+			if atomic.LoadUint32(&player.state) == stateGame {
+				size = proto.ClientPacketSize(id, player.extensions())
 			}
 		}
 
@@ -703,36 +1390,64 @@ func (player *Player) handle() {
 		buffer = buffer[:size]
 		_, err = io.ReadFull(player.conn, buffer[1:])
 		if err != nil {
-			player.Disconnect()
+			player.handleReadError(err)
 			return
 		}
 
+		player.record(RecordInbound, buffer)
+		if !player.checkRateLimit(id) {
+			break
+		}
+
 		reader := bytes.NewReader(buffer)
 		switch id {
-		case packetTypeIdentification:
+		case proto.PacketTypeIdentification:
 			player.handleIdentification(reader)
-		case packetTypeSetBlockClient:
+		case proto.PacketTypeSetBlockClient:
 			player.handleSetBlock(reader)
-		case packetTypePlayerTeleport:
+		case proto.PacketTypePlayerTeleport:
 			player.handleTeleport(reader)
-		case packetTypeMessage:
+		case proto.PacketTypeMessage:
 			player.handleMessage(reader)
-		case packetTypeExtInfo:
+		case proto.PacketTypeExtInfo:
 			player.handleExtInfo(reader)
-		case packetTypeExtEntry:
+		case proto.PacketTypeExtEntry:
 			player.handleExtEntry(reader)
-		case packetTypeCustomBlockSupportLevel:
+		case proto.PacketTypeCustomBlockSupportLevel:
 			player.handleCustomBlockSupportLevel(reader)
-		case packetTypePlayerClicked:
+		case proto.PacketTypePlayerClicked:
 			player.handlePlayerClicked(reader)
-		case packetTypeTwoWayPing:
+		case proto.PacketTypeTwoWayPing:
 			player.handleTwoWayPing(reader)
+		case proto.PacketTypePluginMessage:
+			player.handlePluginMessage(reader)
+		}
+	}
+}
+
+func (player *Player) handleReadError(err error) {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		if atomic.LoadUint32(&player.state) == stateLogin {
+			player.Kick("Login timed out!")
+		} else {
+			player.Kick("Timed out!")
 		}
+
+		return
 	}
+
+	player.Disconnect()
 }
 
 func (player *Player) login() {
-	if player.state != stateLogin {
+	if atomic.LoadUint32(&player.state) != stateLogin {
+		return
+	}
+
+	player.server.closingLock.RLock()
+	defer player.server.closingLock.RUnlock()
+	if player.server.closing {
+		player.Kick("Server shutting down!")
 		return
 	}
 
@@ -749,7 +1464,7 @@ func (player *Player) login() {
 	}
 
 	for {
-		count := player.server.playerCount
+		count := atomic.LoadInt32(&player.server.playerCount)
 		if int(count) >= player.server.Config.MaxPlayers {
 			player.Kick("Server full!")
 			return
@@ -760,8 +1475,10 @@ func (player *Player) login() {
 		}
 	}
 
-	if player.cpe[CpeBlockDefinitions] {
+	if player.cpe[CpeBlockDefinitions] && player.cpe[CpeExtendedBlocks] {
 		player.maxBlockID = BlockMax
+	} else if player.cpe[CpeBlockDefinitions] {
+		player.maxBlockID = BlockMaxDefinitions
 	} else if player.cpe[CpeCustomBlocks] && player.cpeBlockLevel == 1 {
 		player.maxBlockID = BlockMaxCPE
 	} else {
@@ -771,11 +1488,7 @@ func (player *Player) login() {
 	joinEvent := EventPlayerJoin{player}
 	player.server.FireEvent(EventTypePlayerJoin, &joinEvent)
 
-	if !player.server.AddEntity(player.Entity) {
-		player.Kick("Server full!")
-		return
-	}
-
+	player.server.AddEntity(player.Entity)
 	player.Entity.player = player
 
 	atomic.StoreUint32(&player.state, stateGame)
@@ -788,38 +1501,45 @@ func (player *Player) login() {
 
 	player.sendHotkeys()
 	player.sendTextColors()
+	player.server.ForEachModel(player.sendModel)
+	player.server.ForEachParticle(player.sendParticle)
 	player.server.BroadcastMessage(ColorYellow + player.name + " has joined the game!")
 
 	if player.server.MainLevel != nil {
 		player.TeleportLevel(player.server.MainLevel)
 	}
 
-	player.pingTicker = time.NewTicker(2 * time.Second)
 	go func() {
-		for range player.pingTicker.C {
-			var packet packet
-			if player.cpe[CpeTwoWayPing] {
-				packet.twoWayPing(1, player.pingBuffer.next())
-			} else {
-				packet.ping()
-			}
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				var packet packet
+				if player.cpe[CpeTwoWayPing] {
+					packet.TwoWayPing(1, player.pingBuffer.next())
+				} else {
+					packet.ping()
+				}
 
-			player.sendPacket(packet)
+				player.sendPacket(packet)
+
+			case <-player.quit:
+				return
+			}
 		}
 	}()
 }
 
-func (player *Player) verify(key []byte) bool {
-	if len(key) != md5.Size {
-		return false
-	}
-
-	data := make([]byte, len(player.server.salt))
-	copy(data, player.server.salt[:])
-	data = append(data, []byte(player.name)...)
+// verify reports whether key was generated for the player by any of the
+// heartbeat services.
+func (player *Player) verify(key string) bool {
+	verified := false
+	player.server.ForEachHeartbeat(func(heartbeat *Heartbeat) {
+		verified = verified || heartbeat.verify(player.name, key)
+	})
 
-	digest := md5.Sum(data)
-	return bytes.Equal(digest[:], key)
+	return verified
 }
 
 func (player *Player) handleIdentification(reader io.Reader) {
@@ -837,7 +1557,7 @@ func (player *Player) handleIdentification(reader io.Reader) {
 		return
 	}
 
-	player.name = trimString(packet.Name)
+	player.name = proto.TrimString(packet.Name)
 	if !IsValidName(player.name) {
 		player.Kick("Invalid name!")
 		return
@@ -848,9 +1568,9 @@ func (player *Player) handleIdentification(reader io.Reader) {
 	player.SkinName = player.name
 	player.ListName = player.name
 
-	key := trimString(packet.VerificationKey)
+	key := proto.TrimString(packet.VerificationKey)
 	if player.server.Config.Verify {
-		if !player.verify([]byte(key)) {
+		if !player.verify(key) {
 			player.Kick("Login failed!")
 			return
 		}
@@ -864,21 +1584,30 @@ func (player *Player) handleIdentification(reader io.Reader) {
 }
 
 func (player *Player) revertBlock(x, y, z int) {
-	player.sendBlockChange(x, y, z, player.level.GetBlock(x, y, z))
+	player.sendBlockChange(x, y, z, player.Level().GetBlock(x, y, z))
+}
+
+// extensions returns the negotiated extensions that change packet layouts.
+func (player *Player) extensions() proto.Extensions {
+	return proto.Extensions{
+		ExtPos:      player.cpe[CpeExtEntityPositions],
+		ExtBlocks:   player.cpe[CpeExtendedBlocks],
+		ExtTextures: player.cpe[CpeExtendedTextures],
+		FastMap:     player.cpe[CpeFastMap],
+	}
 }
 
 func (player *Player) handleSetBlock(reader io.Reader) {
 	packet := struct {
-		PacketID  byte
-		X, Y, Z   int16
-		Mode      byte
-		BlockType byte
+		PacketID byte
+		X, Y, Z  int16
+		Mode     byte
 	}{}
 	binary.Read(reader, binary.BigEndian, &packet)
 	x, y, z := int(packet.X), int(packet.Y), int(packet.Z)
-	block := packet.BlockType
+	block := proto.ReadBlockID(reader, player.cpe[CpeExtendedBlocks])
 
-	level := player.level
+	level := player.Level()
 	if !level.InBounds(x, y, z) {
 		return
 	}
@@ -903,7 +1632,7 @@ func (player *Player) handleSetBlock(reader io.Reader) {
 			return
 		}
 
-		event := &EventBlockBreak{player, level, oldBlock, x, y, z, false}
+		event := EventBlockBreak{player, level, oldBlock, x, y, z, false}
 		player.server.FireEvent(EventTypeBlockBreak, &event)
 		if event.Cancel {
 			player.revertBlock(x, y, z)
@@ -926,7 +1655,7 @@ func (player *Player) handleSetBlock(reader io.Reader) {
 			return
 		}
 
-		event := &EventBlockPlace{player, level, block, oldBlock, x, y, z, false}
+		event := EventBlockPlace{player, level, block, oldBlock, x, y, z, false}
 		player.server.FireEvent(EventTypeBlockPlace, &event)
 		if event.Cancel {
 			player.revertBlock(x, y, z)
@@ -938,23 +1667,12 @@ func (player *Player) handleSetBlock(reader io.Reader) {
 }
 
 func (player *Player) handleTeleport(reader io.Reader) {
-	packet0 := struct{ PacketID, PlayerID byte }{}
+	var packet0 struct{ PacketID byte }
 	binary.Read(reader, binary.BigEndian, &packet0)
+	playerID := proto.ReadBlockID(reader, player.cpe[CpeExtendedBlocks])
 
 	location := Location{}
-	if player.cpe[CpeExtEntityPositions] {
-		packet1 := struct{ X, Y, Z int32 }{}
-		binary.Read(reader, binary.BigEndian, &packet1)
-		location.X = float64(packet1.X) / 32
-		location.Y = float64(packet1.Y) / 32
-		location.Z = float64(packet1.Z) / 32
-	} else {
-		packet1 := struct{ X, Y, Z int16 }{}
-		binary.Read(reader, binary.BigEndian, &packet1)
-		location.X = float64(packet1.X) / 32
-		location.Y = float64(packet1.Y) / 32
-		location.Z = float64(packet1.Z) / 32
-	}
+	location.X, location.Y, location.Z = proto.ReadPosition(reader, player.cpe[CpeExtEntityPositions])
 
 	packet2 := struct{ Yaw, Pitch byte }{}
 	binary.Read(reader, binary.BigEndian, &packet2)
@@ -962,23 +1680,35 @@ func (player *Player) handleTeleport(reader io.Reader) {
 	location.Pitch = float64(packet2.Pitch) * 360 / 256
 
 	if player.cpe[CpeHeldBlock] {
-		player.heldBlock = packet0.PlayerID
-	} else if packet0.PlayerID != 0xff {
+		player.heldBlock = playerID
+	} else if playerID != 0xff {
 		return
 	}
 
-	if player.level == nil || location == player.location {
+	level, from := player.Level(), player.Location()
+	if level == nil || location == from {
 		return
 	}
 
-	event := &EventEntityMove{player.Entity, player.location, location, false}
+	if player.server.Config.CheckMovement {
+		if violation, ok := player.checkMovement(level, from, location); !ok {
+			event := EventMovementViolation{player, from, location, violation, false}
+			player.server.FireEvent(EventTypeMovementViolation, &event)
+			if !event.Cancel {
+				player.sendTeleport(player.Entity)
+				return
+			}
+		}
+	}
+
+	event := EventEntityMove{player.Entity, from, location, false}
 	player.server.FireEvent(EventTypeEntityMove, &event)
 	if event.Cancel {
 		player.sendTeleport(player.Entity)
 		return
 	}
 
-	player.location = location
+	player.setLocation(location)
 }
 
 func (player *Player) handleMessage(reader io.Reader) {
@@ -989,7 +1719,7 @@ func (player *Player) handleMessage(reader io.Reader) {
 	}{}
 	binary.Read(reader, binary.BigEndian, &packet)
 
-	player.message += trimString(packet.Message)
+	player.message += proto.TrimString(packet.Message)
 	if packet.PlayerID != 0x00 && player.cpe[CpeLongerMessages] {
 		return
 	}
@@ -1053,7 +1783,7 @@ func (player *Player) handleExtEntry(reader io.Reader) {
 	binary.Read(reader, binary.BigEndian, &packet0)
 
 	for i, extension := range Extensions {
-		if extension.Name == trimString(packet0.ExtName) {
+		if extension.Name == proto.TrimString(packet0.ExtName) {
 			if extension.Version == int(packet0.Version) {
 				player.cpe[i] = true
 				break
@@ -1065,7 +1795,7 @@ func (player *Player) handleExtEntry(reader io.Reader) {
 	if player.remExtensions == 0 {
 		if player.cpe[CpeCustomBlocks] {
 			var packet1 packet
-			packet1.customBlockSupportLevel(1)
+			packet1.CustomBlockSupportLevel(1)
 			player.sendPacket(packet1)
 		} else {
 			player.login()
@@ -1096,7 +1826,7 @@ func (player *Player) handlePlayerClicked(reader io.Reader) {
 
 	var target *Entity = nil
 	if packet.TargetID != 0xff {
-		target = player.server.FindEntityByID(packet.TargetID)
+		target = player.FindEntityByID(packet.TargetID)
 	}
 
 	event := EventPlayerClick{
@@ -1111,6 +1841,18 @@ func (player *Player) handlePlayerClicked(reader io.Reader) {
 	player.server.FireEvent(EventTypePlayerClick, &event)
 }
 
+func (player *Player) handlePluginMessage(reader io.Reader) {
+	packet := struct {
+		PacketID byte
+		Channel  byte
+		Data     [64]byte
+	}{}
+	binary.Read(reader, binary.BigEndian, &packet)
+
+	event := EventPluginMessage{player, packet.Channel, packet.Data[:]}
+	player.server.FireEvent(EventTypePluginMessage, &event)
+}
+
 func (player *Player) handleTwoWayPing(reader io.Reader) {
 	packet0 := struct {
 		PacketID  byte
@@ -1122,7 +1864,7 @@ func (player *Player) handleTwoWayPing(reader io.Reader) {
 	switch packet0.Direction {
 	case 0:
 		var packet1 packet
-		packet1.twoWayPing(0, packet0.Data)
+		packet1.TwoWayPing(0, packet0.Data)
 		player.sendPacket(packet1)
 
 	case 1:
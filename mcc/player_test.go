@@ -0,0 +1,232 @@
+package mcc_test
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+	"github.com/AndreasGoulas/go-mcc/mcc/client"
+	"github.com/AndreasGoulas/go-mcc/mcc/internal/proto"
+	"github.com/AndreasGoulas/go-mcc/mcc/mcctest"
+)
+
+// nearby returns the coordinates of a block in reach of the player.
+func nearby(player *mcctest.Player) (x, y, z int) {
+	location := player.Location()
+	return int(math.Floor(location.X)) + 1, int(math.Floor(location.Y)) - 1, int(math.Floor(location.Z))
+}
+
+// isSetBlock returns a filter for SetBlock packets at the specified
+// coordinates with the specified block, as sent to clients without the
+// ExtendedBlocks extension.
+func isSetBlock(x, y, z int, block uint16) func([]byte) bool {
+	return func(data []byte) bool {
+		return int(int16(binary.BigEndian.Uint16(data[1:]))) == x &&
+			int(int16(binary.BigEndian.Uint16(data[3:]))) == y &&
+			int(int16(binary.BigEndian.Uint16(data[5:]))) == z &&
+			uint16(data[7]) == block
+	}
+}
+
+func TestSetBlock(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	alice := harness.Connect("alice", nil)
+	bob := harness.Connect("bob", mcctest.NoExtensions)
+	level := harness.Server.MainLevel
+
+	x, y, z := nearby(alice)
+	alice.SetBlock(x, y, z, mcc.BlockStone)
+	bob.Expect(proto.PacketTypeSetBlock, isSetBlock(x, y, z, mcc.BlockStone))
+	if block := level.GetBlock(x, y, z); block != mcc.BlockStone {
+		t.Fatalf("block = %d, want %d", block, mcc.BlockStone)
+	}
+
+	alice.SetBlock(x, y, z, mcc.BlockAir)
+	bob.Expect(proto.PacketTypeSetBlock, isSetBlock(x, y, z, mcc.BlockAir))
+	if block := level.GetBlock(x, y, z); block != mcc.BlockAir {
+		t.Fatalf("block = %d, want %d", block, mcc.BlockAir)
+	}
+}
+
+func TestSetBlockExtended(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	level := harness.Server.MainLevel
+	level.BlockDefs = make([]*mcc.BlockDefinition, 301)
+	level.BlockDefs[300] = &mcc.BlockDefinition{Name: "Marble", Fallback: mcc.BlockStone}
+
+	alice := harness.Connect("alice", nil)
+	bob := harness.Connect("bob", nil)
+	carol := harness.Connect("carol", mcctest.NoExtensions)
+
+	x, y, z := nearby(alice)
+	alice.SetBlock(x, y, z, 300)
+
+	// Clients with the ExtendedBlocks extension receive the 10-bit ID, and
+	// other clients receive the fallback.
+	bob.Expect(proto.PacketTypeSetBlock, func(data []byte) bool {
+		return int(int16(binary.BigEndian.Uint16(data[1:]))) == x &&
+			int(int16(binary.BigEndian.Uint16(data[3:]))) == y &&
+			int(int16(binary.BigEndian.Uint16(data[5:]))) == z &&
+			len(data) == 9 && binary.BigEndian.Uint16(data[7:]) == 300
+	})
+	carol.Expect(proto.PacketTypeSetBlock, isSetBlock(x, y, z, mcc.BlockStone))
+	if block := level.GetBlock(x, y, z); block != 300 {
+		t.Fatalf("block = %d, want 300", block)
+	}
+}
+
+func TestSetBlockDenied(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	alice := harness.Connect("alice", mcctest.NoExtensions)
+	level := harness.Server.MainLevel
+
+	x, y, z := nearby(alice)
+	old := level.GetBlock(x, y, z)
+	alice.SetBlock(x, y, z, mcc.BlockBedrock)
+	alice.ExpectMessage("You cannot place that block.")
+	alice.Expect(proto.PacketTypeSetBlock, isSetBlock(x, y, z, old))
+	if block := level.GetBlock(x, y, z); block != old {
+		t.Fatalf("block = %d, want %d", block, old)
+	}
+}
+
+func TestSetBlockCancel(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	harness.Server.AddHandler(mcc.EventTypeBlockPlace, func(eventType int, event interface{}) {
+		e := event.(*mcc.EventBlockPlace)
+		e.Cancel = e.Block == mcc.BlockGold
+	})
+
+	alice := harness.Connect("alice", mcctest.NoExtensions)
+	level := harness.Server.MainLevel
+
+	x, y, z := nearby(alice)
+	old := level.GetBlock(x, y, z)
+	alice.SetBlock(x, y, z, mcc.BlockGold)
+	alice.Expect(proto.PacketTypeSetBlock, isSetBlock(x, y, z, old))
+	if block := level.GetBlock(x, y, z); block != old {
+		t.Fatalf("block = %d, want %d", block, old)
+	}
+}
+
+func TestChat(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	alice := harness.Connect("alice", nil)
+	bob := harness.Connect("bob", mcctest.NoExtensions)
+
+	alice.SendMessage("hello")
+	alice.ExpectMessage("alice: &fhello")
+	bob.ExpectMessage("alice: &fhello")
+
+	long := strings.Repeat("a", 50) + " " + strings.Repeat("b", 50)
+	alice.SendMessage(long)
+	bob.ExpectMessage(strings.Repeat("a", 50))
+	bob.ExpectMessage(strings.Repeat("b", 50))
+}
+
+func TestCommandPermissions(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	harness.Server.AddCommand(&mcc.Command{
+		Name:        "secret",
+		Permissions: 1,
+		Handler: func(sender mcc.CommandSender, command *mcc.Command, message string) {
+			sender.SendMessage("secret: " + message)
+		},
+	})
+
+	alice := harness.Connect("alice", nil)
+	alice.SendMessage("/secret one")
+	alice.ExpectMessage("You do not have permission")
+
+	harness.Server.FindPlayer("alice").Rank = &mcc.Rank{Permissions: 1}
+	alice.SendMessage("/secret two")
+	alice.ExpectMessage("secret: two")
+}
+
+func TestLevelSwitch(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	alice := harness.Connect("alice", nil)
+	bob := harness.Connect("bob", mcctest.NoExtensions)
+	bob.Expect(proto.PacketTypeAddEntity, nil)
+
+	level := mcc.NewLevel("other", 32, 16, 32)
+	harness.Server.AddLevel(level)
+	harness.Server.FindPlayer("alice").TeleportLevel(level)
+
+	alice.WaitUntil(func() bool {
+		level := alice.Level()
+		return level.Width == 32 && level.Height == 16 && level.Length == 32
+	})
+	bob.Expect(proto.PacketTypeRemoveEntity, nil)
+
+	var count int
+	alice.ForEachEntity(func(entity *client.Entity) {
+		if entity.ID != client.SelfID {
+			count++
+		}
+	})
+	if count != 0 {
+		t.Fatalf("entities = %d, want 0", count)
+	}
+
+	harness.Server.FindPlayer("alice").TeleportLevel(harness.Server.MainLevel)
+	bob.Expect(proto.PacketTypeAddEntity, nil)
+	alice.WaitUntil(func() bool {
+		return alice.Level().Width == harness.Server.MainLevel.Width
+	})
+
+	alice.ExpectNone(proto.PacketTypeKick, 100*time.Millisecond)
+}
+
+func TestBulkBlockUpdate(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	alice := harness.Connect("alice", nil)
+	bob := harness.Connect("bob", mcctest.NoExtensions)
+	level := harness.Server.MainLevel
+
+	for x := 0; x < 300; x++ {
+		level.SetBlock(x%level.Width, 1, x/level.Width, mcc.BlockGold)
+	}
+
+	alice.Expect(proto.PacketTypeBulkBlockUpdate, nil)
+	alice.WaitUntil(func() bool {
+		return alice.GetBlock(299%level.Width, 1, 299/level.Width) == mcc.BlockGold
+	})
+	bob.Expect(proto.PacketTypeSetBlock, isSetBlock(299%level.Width, 1, 299/level.Width, mcc.BlockGold))
+}
+
+func TestSimulatedVelocity(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	alice := harness.Connect("alice", mcctest.NoExtensions)
+	player := harness.Server.FindPlayer("alice")
+	start := player.Location()
+
+	// VelocitySet replaces the pending velocity instead of adding to it,
+	// which would cancel out the horizontal motion.
+	player.SetVelocity(1, 0.5, 0, mcc.VelocityAdd)
+	player.SetVelocity(-1, 0.5, 0, mcc.VelocitySet)
+	alice.WaitUntil(func() bool {
+		return alice.Location().X < start.X-3
+	})
+}
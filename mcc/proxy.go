@@ -0,0 +1,186 @@
+package mcc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var proxySignatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyConn is a net.Conn whose remote address was taken from a PROXY
+// protocol header.
+type proxyConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+// RemoteAddr implements net.Conn.
+func (conn *proxyConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+// parseTrustedProxies parses a list of CIDRs or single IP addresses.
+func parseTrustedProxies(list []string) (nets []*net.IPNet) {
+	for _, entry := range list {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip)
+				if ip4 := ip.To4(); ip4 != nil {
+					ip, bits = ip4, 32
+				}
+
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("parseTrustedProxies: %s\n", err)
+			continue
+		}
+
+		nets = append(nets, ipNet)
+	}
+
+	return
+}
+
+func (server *Server) isTrustedProxy(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, ipNet := range server.trustedProxies {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header and returns the
+// address of the client. If the header does not carry an address, the
+// remote address of conn is returned. The header must arrive before the
+// read deadline of conn.
+func readProxyHeader(conn net.Conn, reader *bufio.Reader) (net.Addr, error) {
+	data, err := reader.Peek(len(proxySignatureV2))
+	if err != nil {
+		return nil, err
+	}
+
+	var addr net.Addr
+	if bytes.Equal(data, proxySignatureV2) {
+		addr, err = readProxyHeaderV2(reader)
+	} else if string(data[:6]) == "PROXY " {
+		addr, err = readProxyHeaderV1(reader)
+	} else {
+		return nil, errors.New("proxy: missing header")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if addr == nil {
+		addr = conn.RemoteAddr()
+	}
+
+	return addr, nil
+}
+
+func readProxyHeaderV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("proxy: invalid v1 header")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("proxy: invalid v1 header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errors.New("proxy: invalid v1 address")
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyHeaderV2(reader *bufio.Reader) (net.Addr, error) {
+	header := struct {
+		Signature [12]byte
+		VerCmd    byte
+		Family    byte
+		Length    uint16
+	}{}
+	if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+
+	if header.VerCmd>>4 != 2 {
+		return nil, errors.New("proxy: invalid v2 version")
+	}
+
+	payload := make([]byte, header.Length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL connections, such as health checks, carry no address.
+	if header.VerCmd&0x0f == 0 {
+		return nil, nil
+	}
+
+	switch header.Family {
+	case 0x11:
+		if len(payload) < 12 {
+			return nil, errors.New("proxy: invalid v2 address")
+		}
+
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:])),
+		}, nil
+
+	case 0x21:
+		if len(payload) < 36 {
+			return nil, errors.New("proxy: invalid v2 address")
+		}
+
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:])),
+		}, nil
+
+	default:
+		return nil, nil
+	}
+}
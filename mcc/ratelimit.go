@@ -0,0 +1,185 @@
+package mcc
+
+import (
+	"net"
+	"time"
+
+	"github.com/AndreasGoulas/go-mcc/mcc/internal/proto"
+)
+
+const (
+	DefaultLoginTimeout = 15 * time.Second
+	DefaultIdleTimeout  = 60 * time.Second
+)
+
+const (
+	RateLimitConnections = iota
+	RateLimitChat
+	RateLimitBlock
+	RateLimitMovement
+)
+
+// RateLimit configures a token bucket. Rate is the number of events allowed
+// per second, and Burst is the number of events allowed at once.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+var defaultRateLimits = map[int]RateLimit{
+	RateLimitChat:     {2, 16},
+	RateLimitBlock:    {40, 200},
+	RateLimitMovement: {40, 100},
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) tokenBucket {
+	return tokenBucket{
+		rate:   limit.Rate,
+		burst:  float64(limit.Burst),
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+}
+
+// take removes a token from the bucket and reports whether one was
+// available.
+func (bucket *tokenBucket) take(now time.Time) bool {
+	return bucket.takeAmount(now, 1)
+}
+
+// takeAmount removes n tokens from the bucket and reports whether they were
+// available.
+func (bucket *tokenBucket) takeAmount(now time.Time, n float64) bool {
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	bucket.last = now
+	if bucket.tokens > bucket.burst {
+		bucket.tokens = bucket.burst
+	}
+
+	if bucket.tokens < n {
+		return false
+	}
+
+	bucket.tokens -= n
+	return true
+}
+
+func (server *Server) rateLimit(kind int) RateLimit {
+	var limit RateLimit
+	switch kind {
+	case RateLimitChat:
+		limit = server.Config.ChatLimit
+	case RateLimitBlock:
+		limit = server.Config.BlockLimit
+	case RateLimitMovement:
+		limit = server.Config.MovementLimit
+	}
+
+	if limit.Rate <= 0 || limit.Burst <= 0 {
+		return defaultRateLimits[kind]
+	}
+
+	return limit
+}
+
+func (server *Server) loginTimeout() time.Duration {
+	if server.Config.LoginTimeout > 0 {
+		return time.Duration(server.Config.LoginTimeout) * time.Second
+	}
+
+	return DefaultLoginTimeout
+}
+
+func (server *Server) idleTimeout() time.Duration {
+	if server.Config.IdleTimeout > 0 {
+		return time.Duration(server.Config.IdleTimeout) * time.Second
+	}
+
+	return DefaultIdleTimeout
+}
+
+// addConnection registers a connection from the specified address.
+// It returns false if the address has too many open connections.
+func (server *Server) addConnection(addr string) bool {
+	server.connectionsLock.Lock()
+	defer server.connectionsLock.Unlock()
+
+	count := server.connections[addr]
+	if server.Config.MaxConnectionsPerIP > 0 && count >= server.Config.MaxConnectionsPerIP {
+		return false
+	}
+
+	server.connections[addr] = count + 1
+	return true
+}
+
+func (server *Server) removeConnection(addr string) {
+	server.connectionsLock.Lock()
+	defer server.connectionsLock.Unlock()
+
+	if server.connections[addr] <= 1 {
+		delete(server.connections, addr)
+	} else {
+		server.connections[addr]--
+	}
+}
+
+// limitConnection registers conn as a connection from addr. If addr has too
+// many open connections, EventRateLimit is dispatched, conn is rejected and
+// false is returned.
+func (server *Server) limitConnection(conn net.Conn, addr string) bool {
+	if server.addConnection(addr) {
+		return true
+	}
+
+	reason := "Too many connections from your IP!"
+	event := EventRateLimit{nil, addr, RateLimitConnections, reason}
+	server.FireEvent(EventTypeRateLimit, &event)
+	rejectConnection(conn, reason)
+	return false
+}
+
+// rejectConnection sends a kick packet with the specified reason and closes
+// conn.
+func rejectConnection(conn net.Conn, reason string) {
+	var packet packet
+	packet.kick(reason)
+	conn.SetWriteDeadline(time.Now().Add(flushTimeout))
+	conn.Write(packet.Bytes())
+	conn.Close()
+}
+
+// checkRateLimit takes a token from the bucket of the specified packet type.
+// If the bucket is empty, EventRateLimit is dispatched and the player is
+// kicked.
+func (player *Player) checkRateLimit(id byte) bool {
+	var bucket *tokenBucket
+	var kind int
+	var reason string
+	switch id {
+	case proto.PacketTypeMessage:
+		bucket, kind, reason = &player.chatBucket, RateLimitChat, "Too many chat messages!"
+	case proto.PacketTypeSetBlockClient:
+		bucket, kind, reason = &player.blockBucket, RateLimitBlock, "Too many block changes!"
+	case proto.PacketTypePlayerTeleport:
+		bucket, kind, reason = &player.moveBucket, RateLimitMovement, "Too many movement packets!"
+	default:
+		return true
+	}
+
+	if bucket.take(time.Now()) {
+		return true
+	}
+
+	event := EventRateLimit{player, player.RemoteAddr(), kind, reason}
+	player.server.FireEvent(EventTypeRateLimit, &event)
+	player.Kick(reason)
+	return false
+}
@@ -0,0 +1,169 @@
+package mcc
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RecordInbound  = 0
+	RecordOutbound = 1
+)
+
+var recordMagic = []byte("MCCREC\x01")
+
+// RecordEntry is a packet stored in a recording. Time is the offset from
+// the start of the recording.
+type RecordEntry struct {
+	Direction byte
+	Time      time.Duration
+	Data      []byte
+}
+
+// Recorder writes the packets of a session to a gzip-compressed stream.
+// Each entry consists of the direction, the time since the previous entry in
+// microseconds and the length of the data, encoded as varints, followed by
+// the data.
+type Recorder struct {
+	closer io.Closer
+	gzip   *gzip.Writer
+	writer *bufio.Writer
+	start  time.Time
+	last   time.Duration
+	closed bool
+	lock   sync.Mutex
+}
+
+// NewRecorder returns a recorder that writes to w. w is closed when the
+// recorder is closed.
+func NewRecorder(w io.WriteCloser) (*Recorder, error) {
+	gz := gzip.NewWriter(w)
+	recorder := &Recorder{
+		closer: w,
+		gzip:   gz,
+		writer: bufio.NewWriter(gz),
+		start:  time.Now(),
+	}
+
+	if _, err := recorder.writer.Write(recordMagic); err != nil {
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+// newSessionRecorder creates a recording file for a connection from addr in
+// the specified directory.
+func newSessionRecorder(dir string, addr string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	addr = strings.NewReplacer(":", "_", "[", "", "]", "").Replace(addr)
+	name := time.Now().Format("20060102-150405.000") + "-" + addr + ".mcr"
+	file, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRecorder(file)
+}
+
+// Record writes a packet with the specified direction.
+func (recorder *Recorder) Record(direction byte, data []byte) error {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	if recorder.closed {
+		return nil
+	}
+
+	now := time.Since(recorder.start)
+	delta := now - recorder.last
+	recorder.last = now
+
+	var header [3 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(header[:], uint64(direction))
+	n += binary.PutUvarint(header[n:], uint64(delta/time.Microsecond))
+	n += binary.PutUvarint(header[n:], uint64(len(data)))
+	if _, err := recorder.writer.Write(header[:n]); err != nil {
+		return err
+	}
+
+	_, err := recorder.writer.Write(data)
+	return err
+}
+
+// Close flushes the recording and closes the underlying writer.
+func (recorder *Recorder) Close() error {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	if recorder.closed {
+		return nil
+	}
+
+	recorder.closed = true
+	recorder.writer.Flush()
+	recorder.gzip.Close()
+	return recorder.closer.Close()
+}
+
+// RecordReader reads the entries of a recording.
+type RecordReader struct {
+	reader *bufio.Reader
+	time   time.Duration
+}
+
+// NewRecordReader returns a reader for the recording stored in r.
+func NewRecordReader(r io.Reader) (*RecordReader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(gz)
+	magic := make([]byte, len(recordMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil {
+		return nil, err
+	}
+
+	if string(magic) != string(recordMagic) {
+		return nil, errors.New("record: invalid header")
+	}
+
+	return &RecordReader{reader: reader}, nil
+}
+
+// Next returns the next entry of the recording, or io.EOF at the end of the
+// recording.
+func (reader *RecordReader) Next() (*RecordEntry, error) {
+	direction, err := binary.ReadUvarint(reader.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	delta, err := binary.ReadUvarint(reader.reader)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	length, err := binary.ReadUvarint(reader.reader)
+	if err != nil || length > 1<<20 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader.reader, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	reader.time += time.Duration(delta) * time.Microsecond
+	return &RecordEntry{byte(direction), reader.time, data}, nil
+}
@@ -0,0 +1,75 @@
+package mcc_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+	"github.com/AndreasGoulas/go-mcc/mcc/internal/proto"
+	"github.com/AndreasGoulas/go-mcc/mcc/mcctest"
+)
+
+func TestRecordSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcc-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	harness := mcctest.NewHarness(t, &mcc.Config{
+		Name:       "Test",
+		MaxPlayers: 16,
+		MainLevel:  "main",
+		RecordDir:  dir,
+	})
+
+	alice := harness.Connect("alice", mcctest.NoExtensions)
+	alice.SendMessage("recorded")
+	alice.ExpectMessage("recorded")
+	harness.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.mcr"))
+	if len(files) != 1 {
+		t.Fatalf("recordings = %d, want 1", len(files))
+	}
+
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := mcc.NewRecordReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var inbound []byte
+	outbound := 0
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		if entry.Direction == mcc.RecordInbound {
+			inbound = append(inbound, entry.Data[0])
+		} else {
+			outbound++
+		}
+	}
+
+	want := []byte{proto.PacketTypeIdentification, proto.PacketTypeMessage}
+	if string(inbound) != string(want) {
+		t.Fatalf("inbound = %x, want %x", inbound, want)
+	}
+
+	if outbound == 0 {
+		t.Fatalf("no outbound packets recorded")
+	}
+}
@@ -1,17 +1,15 @@
 package mcc
 
 import (
-	"encoding/json"
+	"bufio"
+	"context"
 	"errors"
-	"io/ioutil"
 	"log"
-	"math/rand"
 	"net"
-	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,6 +31,38 @@ type Config struct {
 	MaxPlayers int    `json:"max-players"`
 	Heartbeat  string `json:"heartbeat,omitempty"`
 	MainLevel  string `json:"main-level"`
+
+	// Heartbeats lists additional heartbeat URLs, so that the server can
+	// be announced to several server lists.
+	Heartbeats []string `json:"heartbeats,omitempty"`
+
+	// Listen lists the addresses that the server accepts connections on,
+	// such as "0.0.0.0:25565" or "[::1]:25565". Addresses without a port
+	// use Port. If it is empty, the server listens on Port on all
+	// interfaces.
+	Listen []string `json:"listen,omitempty"`
+
+	// ProxyProtocol enables parsing of PROXY protocol headers. Only
+	// connections from TrustedProxies, a list of CIDRs, are accepted.
+	ProxyProtocol  bool     `json:"proxy-protocol,omitempty"`
+	TrustedProxies []string `json:"trusted-proxies,omitempty"`
+
+	// The following limits use default values if they are not set.
+	// Timeouts are specified in seconds.
+	MaxConnectionsPerIP int       `json:"max-connections-per-ip,omitempty"`
+	LoginTimeout        int       `json:"login-timeout,omitempty"`
+	IdleTimeout         int       `json:"idle-timeout,omitempty"`
+	ChatLimit           RateLimit `json:"chat-limit,omitempty"`
+	BlockLimit          RateLimit `json:"block-limit,omitempty"`
+	MovementLimit       RateLimit `json:"movement-limit,omitempty"`
+
+	// CheckMovement enables the validation of player movement against the
+	// HackConfig of the level.
+	CheckMovement bool `json:"check-movement,omitempty"`
+
+	// RecordDir is the directory in which the packets of every session are
+	// recorded. Sessions are not recorded if it is empty.
+	RecordDir string `json:"record-dir,omitempty"`
 }
 
 // Plugin is the interface that must be implemented by all plugins.
@@ -46,12 +76,12 @@ type Plugin interface {
 type Server struct {
 	Config    *Config
 	MainLevel *Level
-	URL       string
 	Colors    []ColorDesc
 	Hotkeys   []HotkeyDesc
 
 	playerCount int32
-	salt        [16]byte
+	heartbeats  []*Heartbeat
+	port        int
 
 	commands     map[string]*Command
 	commandsLock sync.RWMutex
@@ -62,6 +92,12 @@ type Server struct {
 	generators     map[string]GeneratorFunc
 	generatorsLock sync.RWMutex
 
+	models     [MaxModels]*Model
+	modelsLock sync.RWMutex
+
+	particles     [256]*Particle
+	particlesLock sync.RWMutex
+
 	storage    LevelStorage
 	levels     []*Level
 	levelsLock sync.RWMutex
@@ -72,29 +108,37 @@ type Server struct {
 	players     []*Player
 	playersLock sync.RWMutex
 
+	closing     bool
+	closingLock sync.RWMutex
+
 	plugins     []Plugin
 	pluginsLock sync.RWMutex
 
-	listener net.Listener
-	stopChan chan bool
+	trustedProxies []*net.IPNet
 
-	updateTicker    *time.Ticker
-	heartbeatTicker *time.Ticker
-	saveTicker      *time.Ticker
+	connections     map[string]int
+	connectionsLock sync.Mutex
+
+	// conns maps the accepted connections to their players, which are nil
+	// during the PROXY and WebSocket handshakes.
+	conns     map[net.Conn]*Player
+	connsLock sync.Mutex
 }
 
 // NewServer returns a new Server.
 func NewServer(config *Config, storage LevelStorage) *Server {
 	server := &Server{
-		Config:     config,
-		commands:   make(map[string]*Command),
-		handlers:   make(map[int][]EventHandler),
-		generators: make(map[string]GeneratorFunc),
-		storage:    storage,
-		stopChan:   make(chan bool),
+		Config:      config,
+		commands:    make(map[string]*Command),
+		handlers:    make(map[int][]EventHandler),
+		generators:  make(map[string]GeneratorFunc),
+		connections: make(map[string]int),
+		conns:       make(map[net.Conn]*Player),
+		storage:     storage,
+		port:        config.Port,
 	}
 
-	server.generateSalt()
+	server.addHeartbeats()
 
 	server.generators["flat"] = NewFlatGenerator
 	mainLevel, err := server.LoadLevel(config.MainLevel)
@@ -113,23 +157,151 @@ func NewServer(config *Config, storage LevelStorage) *Server {
 	return server
 }
 
-// Start starts the server.
-// When the server is stopped, wg will be notified.
-func (server *Server) Start(wg *sync.WaitGroup) (err error) {
-	addr := net.TCPAddr{Port: server.Config.Port}
-	if server.listener, err = net.ListenTCP("tcp", &addr); err != nil {
-		return
+// Listen opens a listener on every configured address. If any of the
+// addresses cannot be listened on, no listener is returned and the error
+// describes every failed address.
+func (server *Server) Listen() ([]net.Listener, error) {
+	var listeners []net.Listener
+	var errs []string
+	for _, addr := range server.listenAddrs() {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	if len(errs) > 0 {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+
+	return listeners, nil
+}
+
+// ListenAndServe listens on the configured addresses and serves connections
+// until ctx is cancelled.
+func (server *Server) ListenAndServe(ctx context.Context) error {
+	listeners, err := server.Listen()
+	if err != nil {
+		return err
+	}
+
+	return server.Serve(ctx, listeners...)
+}
+
+// Serve accepts connections from listeners until ctx is cancelled or one of
+// the listeners fails. It then closes the listeners, rejects further logins,
+// disconnects all players and the connections that have not logged in yet,
+// waits for them, saves all levels and disables all plugins before returning.
+// The returned error describes the failed listeners and levels.
+func (server *Server) Serve(ctx context.Context, listeners ...net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	server.trustedProxies = parseTrustedProxies(server.Config.TrustedProxies)
+	server.port = listenPort(listeners, server.Config.Port)
+
+	var conns sync.WaitGroup
+	acceptErrs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			acceptErrs <- server.accept(ctx, listener, &conns)
+		}(listener)
+	}
+
+	var wg sync.WaitGroup
+	server.runTickers(ctx, &wg)
+
+	var errs []string
+	pending := len(listeners)
+	select {
+	case <-ctx.Done():
+	case err := <-acceptErrs:
+		pending--
+		errs = append(errs, err.Error())
+	}
+
+	cancel()
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	for ; pending > 0; pending-- {
+		if err := <-acceptErrs; err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	wg.Wait()
+
+	// Logins in progress finish before the players are kicked, and the
+	// connections that have not logged in yet are rejected when they do.
+	server.closingLock.Lock()
+	server.closing = true
+	server.closingLock.Unlock()
+
+	server.playersLock.RLock()
+	players := make([]*Player, len(server.players))
+	copy(players, server.players)
+	server.playersLock.RUnlock()
+
+	for _, player := range players {
+		player.Kick("Server shutting down!")
 	}
+	server.closeConns()
+	conns.Wait()
 
-	go server.run(wg)
-	return
+	server.levelsLock.Lock()
+	for _, level := range server.levels {
+		if err := server.saveLevel(level); err != nil {
+			errs = append(errs, level.Name+": "+err.Error())
+		}
+	}
+	server.levels = nil
+	server.levelsLock.Unlock()
+
+	server.DisablePlugins()
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
 }
 
-// Stop stops the server, disconnects all clients, disables all plugins and
-// unloads all levels.
-func (server *Server) Stop() {
-	server.listener.Close()
-	server.stopChan <- true
+// listenAddrs returns the configured listen addresses, adding the default
+// port to the addresses that lack one.
+func (server *Server) listenAddrs() []string {
+	port := strconv.Itoa(server.Config.Port)
+	if len(server.Config.Listen) == 0 {
+		return []string{":" + port}
+	}
+
+	addrs := make([]string, len(server.Config.Listen))
+	for i, addr := range server.Config.Listen {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(strings.Trim(addr, "[]"), port)
+		}
+		addrs[i] = addr
+	}
+
+	return addrs
+}
+
+// listenPort returns the port of the first TCP listener, which is the port
+// announced by the heartbeats, or port if there is none.
+func listenPort(listeners []net.Listener, port int) int {
+	for _, listener := range listeners {
+		if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+			return addr.Port
+		}
+	}
+
+	return port
 }
 
 // BroadcastMessage broadcasts a message to all players.
@@ -164,8 +336,6 @@ func (server *Server) RemoveLevel(level *Level) {
 	}
 
 	server.levelsLock.Lock()
-	defer server.levelsLock.Unlock()
-
 	index := -1
 	for i, l := range server.levels {
 		if l == level {
@@ -175,6 +345,7 @@ func (server *Server) RemoveLevel(level *Level) {
 	}
 
 	if index == -1 {
+		server.levelsLock.Unlock()
 		return
 	}
 
@@ -182,14 +353,16 @@ func (server *Server) RemoveLevel(level *Level) {
 		server.MainLevel = nil
 	}
 
+	server.levels[index] = server.levels[len(server.levels)-1]
+	server.levels[len(server.levels)-1] = nil
+	server.levels = server.levels[:len(server.levels)-1]
+	server.levelsLock.Unlock()
+
 	level.ForEachPlayer(func(player *Player) {
 		player.TeleportLevel(server.MainLevel)
 	})
 
 	level.server = nil
-	server.levels[index] = server.levels[len(server.levels)-1]
-	server.levels[len(server.levels)-1] = nil
-	server.levels = server.levels[:len(server.levels)-1]
 
 	event := EventLevelUnload{level}
 	server.FireEvent(EventTypeLevelUnload, &event)
@@ -240,17 +413,19 @@ func (server *Server) LoadLevel(name string) (*Level, error) {
 
 // SaveLevel saves level.
 func (server *Server) SaveLevel(level *Level) {
+	if err := server.saveLevel(level); err != nil {
+		log.Printf("SaveLevel: %s\n", err.Error())
+	}
+}
+
+func (server *Server) saveLevel(level *Level) error {
 	if server.storage == nil || !level.Dirty {
-		return
+		return nil
 	}
 
 	event := EventLevelSave{level}
 	server.FireEvent(EventTypeLevelSave, &event)
-
-	err := server.storage.Save(level)
-	if err != nil {
-		log.Printf("SaveLevel: %s\n", err.Error())
-	}
+	return server.storage.Save(level)
 }
 
 // UnloadLevel saves and removes level from the server.
@@ -261,22 +436,15 @@ func (server *Server) UnloadLevel(level *Level) {
 }
 
 // AddEntity adds entity to the server.
-// It returns true on success, or false if the server is full.
-func (server *Server) AddEntity(entity *Entity) bool {
+func (server *Server) AddEntity(entity *Entity) {
 	server.entitiesLock.Lock()
 	defer server.entitiesLock.Unlock()
 
-	entity.id = server.generateID()
-	if entity.id == 0xff {
-		return false
-	}
-
+	atomic.StoreUint32(&entity.id, uint32(server.generateID()))
 	server.entities = append(server.entities, entity)
 	server.ForEachPlayer(func(player *Player) {
 		player.sendAddPlayerList(entity)
 	})
-
-	return true
 }
 
 // RemoveEntity removes entity from the server.
@@ -299,6 +467,11 @@ func (server *Server) RemoveEntity(entity *Entity) {
 	server.entities[index] = server.entities[len(server.entities)-1]
 	server.entities[len(server.entities)-1] = nil
 	server.entities = server.entities[:len(server.entities)-1]
+	atomic.StoreUint32(&entity.id, 0xff)
+
+	if level := entity.Level(); level != nil {
+		level.removeEntity(entity)
+	}
 
 	server.ForEachPlayer(func(player *Player) {
 		player.sendRemovePlayerList(entity)
@@ -319,13 +492,16 @@ func (server *Server) FindEntity(name string) *Entity {
 	return nil
 }
 
-// FindEntityByID returns the entity with the specified ID.
+// FindEntityByID returns the entity with the specified server-wide ID.
+//
+// Deprecated: Players know entities by different IDs. Use
+// Player.FindEntityByID.
 func (server *Server) FindEntityByID(id byte) *Entity {
 	server.entitiesLock.RLock()
 	defer server.entitiesLock.RUnlock()
 
 	for _, entity := range server.entities {
-		if entity.id == id {
+		if entity.ID() == id && id != 0xff {
 			return entity
 		}
 	}
@@ -333,6 +509,25 @@ func (server *Server) FindEntityByID(id byte) *Entity {
 	return nil
 }
 
+// generateID returns the lowest server-wide entity ID that is not in use, or
+// 0xff if all of them are.
+func (server *Server) generateID() byte {
+	var used [0xff]bool
+	for _, entity := range server.entities {
+		if id := entity.ID(); id != 0xff {
+			used[id] = true
+		}
+	}
+
+	for id, inUse := range used {
+		if !inUse {
+			return byte(id)
+		}
+	}
+
+	return 0xff
+}
+
 // ForEachEntity calls fn for each entity.
 func (server *Server) ForEachEntity(fn func(*Entity)) {
 	server.entitiesLock.RLock()
@@ -502,164 +697,245 @@ func (server *Server) AddPlugin(plugin Plugin) {
 	plugin.Enable(server)
 }
 
-func (server *Server) generateSalt() {
-	const charset = "abcdefghijklmnopqrstuvwxyz" +
-		"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
-		"0123456789"
-	for i := range server.salt {
-		server.salt[i] = charset[rand.Intn(len(charset))]
+// DisablePlugins disables and removes all plugins.
+func (server *Server) DisablePlugins() {
+	server.pluginsLock.Lock()
+	defer server.pluginsLock.Unlock()
+	for _, plugin := range server.plugins {
+		plugin.Disable(server)
 	}
+	server.plugins = nil
 }
 
-func (server *Server) generateID() byte {
-	for id := byte(0); id < 0xff; id++ {
-		free := true
-		for _, entity := range server.entities {
-			if entity.id == id {
-				free = false
+func (server *Server) addHeartbeats() {
+	urls := append([]string{server.Config.Heartbeat}, server.Config.Heartbeats...)
+	for _, url := range urls {
+		if len(url) == 0 {
+			continue
+		}
+
+		duplicate := false
+		for _, heartbeat := range server.heartbeats {
+			if heartbeat.url == url {
+				duplicate = true
 				break
 			}
 		}
 
-		if free {
-			return id
+		if !duplicate {
+			server.heartbeats = append(server.heartbeats, newHeartbeat(url))
 		}
 	}
+}
 
-	return 0xff
+// ForEachHeartbeat calls fn for each heartbeat service.
+func (server *Server) ForEachHeartbeat(fn func(*Heartbeat)) {
+	for _, heartbeat := range server.heartbeats {
+		fn(heartbeat)
+	}
 }
 
-func (server *Server) run(wg *sync.WaitGroup) {
-	wg.Add(1)
+// runTickers starts the goroutines that update the server, save the levels
+// and send the heartbeats until ctx is cancelled.
+func (server *Server) runTickers(ctx context.Context, wg *sync.WaitGroup) {
+	tick := func(interval time.Duration, fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					fn()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
 
-	server.updateTicker = time.NewTicker(UpdateInterval)
-	go func() {
-		for range server.updateTicker.C {
-			server.ForEachEntity(func(entity *Entity) {
-				entity.update()
-			})
+	tick(UpdateInterval, func() {
+		server.ForEachPlayer(func(player *Player) {
+			player.updateVelocity()
+		})
 
-			server.ForEachLevel(func(level *Level) {
-				level.update()
-			})
-		}
-	}()
+		server.ForEachEntity(func(entity *Entity) {
+			entity.update()
+		})
+
+		server.ForEachLevel(func(level *Level) {
+			level.update()
+		})
+
+		server.ForEachPlayer(func(player *Player) {
+			player.flush()
+		})
+	})
 
 	if SaveInterval > 0 {
-		server.saveTicker = time.NewTicker(SaveInterval)
-		go func() {
-			for range server.saveTicker.C {
-				server.ForEachLevel(func(level *Level) {
-					server.SaveLevel(level)
-				})
-			}
-		}()
+		tick(SaveInterval, func() {
+			server.ForEachLevel(func(level *Level) {
+				server.SaveLevel(level)
+			})
+		})
 	}
 
-	if HeartbeatInterval > 0 && len(server.Config.Heartbeat) > 0 {
-		server.heartbeatTicker = time.NewTicker(HeartbeatInterval)
+	if HeartbeatInterval > 0 && len(server.heartbeats) > 0 {
+		wg.Add(1)
 		go func() {
-			server.sendHeartbeat()
-			for range server.heartbeatTicker.C {
-				server.sendHeartbeat()
-			}
+			defer wg.Done()
+			server.sendHeartbeat(ctx)
 		}()
+
+		tick(HeartbeatInterval, func() {
+			server.sendHeartbeat(ctx)
+		})
 	}
+}
 
+// accept accepts connections from listener until it is closed, and adds
+// their goroutines to conns. It returns nil if the listener was closed
+// because ctx was cancelled.
+func (server *Server) accept(ctx context.Context, listener net.Listener, conns *sync.WaitGroup) error {
 	for {
-		select {
-		case <-server.stopChan:
-			server.updateTicker.Stop()
-			server.saveTicker.Stop()
-			if server.heartbeatTicker != nil {
-				server.heartbeatTicker.Stop()
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
 			}
 
-			server.playersLock.RLock()
-			players := make([]*Player, len(server.players))
-			copy(players, server.players)
-			server.playersLock.RUnlock()
-
-			for _, player := range players {
-				player.Kick("Server shutting down!")
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
 			}
 
-			server.levelsLock.Lock()
-			for _, level := range server.levels {
-				server.SaveLevel(level)
-			}
-			server.levels = nil
-			server.levelsLock.Unlock()
+			return err
+		}
 
-			server.pluginsLock.Lock()
-			for _, plugin := range server.plugins {
-				plugin.Disable(server)
-			}
-			server.plugins = nil
-			server.pluginsLock.Unlock()
+		server.connsLock.Lock()
+		server.conns[conn] = nil
+		server.connsLock.Unlock()
 
-			wg.Done()
-			return
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			server.serve(conn)
 
-		default:
-			conn, err := server.listener.Accept()
-			if err != nil {
-				continue
-			}
+			server.connsLock.Lock()
+			delete(server.conns, conn)
+			server.connsLock.Unlock()
+		}()
+	}
+}
 
-			player := NewPlayer(conn, server)
-			go player.handle()
+// closeConns disconnects the connections that have not logged in, so that
+// clients that do not finish their handshakes cannot delay the shutdown.
+func (server *Server) closeConns() {
+	server.connsLock.Lock()
+	defer server.connsLock.Unlock()
+	for conn, player := range server.conns {
+		if player != nil {
+			player.Kick("Server shutting down!")
+		} else {
+			conn.Close()
 		}
 	}
 }
 
-func (server *Server) sendHeartbeat() {
-	form := url.Values{}
-	form.Add("name", server.Config.Name)
-	form.Add("port", strconv.Itoa(server.Config.Port))
-	form.Add("max", strconv.Itoa(server.Config.MaxPlayers))
-	form.Add("users", strconv.Itoa(int(server.playerCount)))
-	form.Add("salt", string(server.salt[:]))
-	form.Add("version", "7")
-	form.Add("software", ServerSoftware)
-	if server.Config.Public {
-		form.Add("public", "True")
-	} else {
-		form.Add("public", "False")
-	}
+func (server *Server) serve(conn net.Conn) {
+	rawConn := conn
 
-	response, err := http.PostForm(server.Config.Heartbeat, form)
-	if err != nil {
-		log.Printf("sendHeartbeat: %s\n", err.Error())
+	// The login timeout also covers the PROXY and WebSocket handshakes, so
+	// that clients cannot hold connections open by not sending anything.
+	conn.SetReadDeadline(time.Now().Add(server.loginTimeout()))
+
+	// Connections are counted before anything is read from them. The
+	// connections of trusted proxies are counted for the address in the
+	// PROXY header instead, as they carry the traffic of many clients.
+	addr := remoteIP(conn.RemoteAddr())
+	trusted := server.Config.ProxyProtocol && server.isTrustedProxy(conn.RemoteAddr())
+	if server.Config.ProxyProtocol && !trusted {
+		log.Printf("serve: untrusted proxy %s\n", conn.RemoteAddr())
+		conn.Close()
 		return
 	}
 
-	if response.StatusCode != 200 {
-		log.Printf("sendHeartbeat: %s\n", response.Status)
+	if !trusted && !server.limitConnection(conn, addr) {
 		return
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Printf("sendHeartbeat: %s\n", err.Error())
-		return
+	reader := bufio.NewReader(conn)
+	if trusted {
+		proxyAddr, err := readProxyHeader(conn, reader)
+		if err != nil {
+			log.Printf("serve: %s\n", err)
+			conn.Close()
+			return
+		}
+
+		conn = &proxyConn{conn, proxyAddr}
+		addr = remoteIP(conn.RemoteAddr())
+		if !server.limitConnection(conn, addr) {
+			return
+		}
+	}
+	defer server.removeConnection(addr)
+
+	if isWebsocketRequest(reader) {
+		wsConn, err := upgradeWebsocket(conn, reader)
+		if err != nil {
+			log.Printf("serve: %s\n", err)
+			conn.Close()
+			return
+		}
+
+		conn = wsConn
+	} else {
+		conn = &bufferedConn{conn, reader}
+	}
+
+	player := NewPlayer(conn, server)
+	server.connsLock.Lock()
+	server.conns[rawConn] = player
+	server.connsLock.Unlock()
+
+	if len(server.Config.RecordDir) > 0 {
+		recorder, err := newSessionRecorder(server.Config.RecordDir, conn.RemoteAddr().String())
+		if err != nil {
+			log.Printf("serve: %s\n", err)
+		} else {
+			player.recorder = recorder
+		}
 	}
 
-	data := struct {
-		Status   string     `json:"status"`
-		Response string     `json:"response"`
-		Errors   [][]string `json:"errors"`
-	}{}
+	player.handle()
+	<-player.done
+}
 
-	err = json.Unmarshal(body, &data)
+// remoteIP returns the IP address of addr in canonical form, so that the
+// same client has the same address whether it connected over IPv4 or IPv6.
+// IPv4-mapped IPv6 addresses are formatted as IPv4 addresses, and IPv6 zones
+// are removed.
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
 	if err != nil {
-		return
+		host = addr.String()
 	}
 
-	if len(data.Errors) > 0 && len(data.Errors[0]) > 0 {
-		log.Printf("sendHeartbeat: %s\n", data.Errors[0][0])
-		return
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		host = host[:i]
 	}
 
-	server.URL = string(body)
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+
+	return host
+}
+
+func (server *Server) sendHeartbeat(ctx context.Context) {
+	server.ForEachHeartbeat(func(heartbeat *Heartbeat) {
+		heartbeat.send(ctx, server)
+	})
 }
@@ -0,0 +1,395 @@
+package mcc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndreasGoulas/go-mcc/mcc"
+	"github.com/AndreasGoulas/go-mcc/mcc/client"
+	"github.com/AndreasGoulas/go-mcc/mcc/internal/proto"
+	"github.com/AndreasGoulas/go-mcc/mcc/mcctest"
+	"github.com/AndreasGoulas/go-mcc/mcc/memnet"
+)
+
+// supportsIPv6 reports whether the loopback interface has an IPv6 address.
+func supportsIPv6() bool {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		return false
+	}
+
+	listener.Close()
+	return true
+}
+
+// startServer serves config on its listen addresses. The returned function
+// stops the server.
+func startServer(t *testing.T, config *mcc.Config) (*mcc.Server, []net.Listener, func()) {
+	t.Helper()
+	server := mcc.NewServer(config, mcc.NewMemoryStorage())
+	if server == nil {
+		t.Fatal("failed to create server")
+	}
+
+	listeners, err := server.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(ctx, listeners...)
+	}()
+
+	return server, listeners, func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %s", err)
+		}
+	}
+}
+
+// login connects to addr as name and waits until the server has added the
+// player. header is written before the login packets.
+func login(t *testing.T, server *mcc.Server, addr net.Addr, name, header string) (*mcc.Player, net.Conn) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(header) > 0 {
+		if _, err := conn.Write([]byte(header)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := client.NewClient(conn, client.Config{
+		Name:       name,
+		Extensions: mcctest.NoExtensions,
+	})
+	if err := c.Login(); err != nil {
+		t.Fatalf("%s: %s", addr, err)
+	}
+
+	deadline := time.Now().Add(mcctest.Timeout)
+	for {
+		if player := server.FindPlayer(name); player != nil {
+			return player, conn
+		} else if time.Now().After(deadline) {
+			t.Fatalf("%s: player %s not found", addr, name)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestListen(t *testing.T) {
+	config := &mcc.Config{
+		Name:       "Test",
+		MaxPlayers: 16,
+		MainLevel:  "main",
+		Listen:     []string{"127.0.0.1:0"},
+	}
+
+	wantAddrs := []string{"127.0.0.1"}
+	if supportsIPv6() {
+		config.Listen = append(config.Listen, "[::1]:0")
+		wantAddrs = append(wantAddrs, "::1")
+	}
+
+	server, listeners, stop := startServer(t, config)
+	defer stop()
+
+	if len(listeners) != len(wantAddrs) {
+		t.Fatalf("Listen() = %d listeners, want %d", len(listeners), len(wantAddrs))
+	}
+
+	for i, listener := range listeners {
+		player, conn := login(t, server, listener.Addr(), "player"+strconv.Itoa(i), "")
+		defer conn.Close()
+		if player.RemoteAddr() != wantAddrs[i] {
+			t.Errorf("RemoteAddr() = %q, want %q", player.RemoteAddr(), wantAddrs[i])
+		}
+	}
+}
+
+func TestListenErrors(t *testing.T) {
+	server := mcc.NewServer(&mcc.Config{
+		Name:       "Test",
+		MaxPlayers: 16,
+		MainLevel:  "main",
+		Listen:     []string{"127.0.0.1:0", "256.0.0.1:0", "[::zz]:0"},
+	}, mcc.NewMemoryStorage())
+
+	listeners, err := server.Listen()
+	if err == nil {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		t.Fatal("Listen succeeded with invalid addresses")
+	}
+
+	for _, addr := range []string{"256.0.0.1", "::zz"} {
+		if !strings.Contains(err.Error(), addr) {
+			t.Errorf("error %q does not mention %s", err, addr)
+		}
+	}
+}
+
+func TestProxyIPv6(t *testing.T) {
+	if !supportsIPv6() {
+		t.Skip("IPv6 is not supported")
+	}
+
+	server, listeners, stop := startServer(t, &mcc.Config{
+		Name:           "Test",
+		MaxPlayers:     16,
+		MainLevel:      "main",
+		Listen:         []string{"[::1]:0"},
+		ProxyProtocol:  true,
+		TrustedProxies: []string{"::1/128"},
+	})
+	defer stop()
+
+	addr := listeners[0].Addr()
+	player, conn := login(t, server, addr, "alice", "PROXY TCP6 2001:DB8::1 ::1 1234 25565\r\n")
+	defer conn.Close()
+	if player.RemoteAddr() != "2001:db8::1" {
+		t.Errorf("RemoteAddr() = %q, want 2001:db8::1", player.RemoteAddr())
+	}
+
+	player, conn = login(t, server, addr, "bob", "PROXY TCP6 ::ffff:10.0.0.1 ::1 1234 25565\r\n")
+	defer conn.Close()
+	if player.RemoteAddr() != "10.0.0.1" {
+		t.Errorf("RemoteAddr() = %q, want 10.0.0.1", player.RemoteAddr())
+	}
+}
+
+// failingStorage is a mcc.LevelStorage that cannot save levels.
+type failingStorage struct {
+	*mcc.MemoryStorage
+}
+
+func (storage failingStorage) Save(level *mcc.Level) error {
+	return errors.New("disk full")
+}
+
+// failingListener is a net.Listener whose Accept fails once accept is
+// closed.
+type failingListener struct {
+	*memnet.Listener
+	accept chan struct{}
+}
+
+func (listener failingListener) Accept() (net.Conn, error) {
+	<-listener.accept
+	return nil, errors.New("accept failed")
+}
+
+func TestServe(t *testing.T) {
+	server := mcc.NewServer(&mcc.Config{
+		Name:       "Test",
+		MaxPlayers: 16,
+		MainLevel:  "main",
+	}, failingStorage{mcc.NewMemoryStorage()})
+
+	listener := memnet.NewListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(ctx, listener)
+	}()
+
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c := client.NewClient(conn, client.Config{
+		Name:       "alice",
+		Extensions: mcctest.NoExtensions,
+	})
+	if err := c.Login(); err != nil {
+		t.Fatal(err)
+	}
+	go c.Run()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "main: disk full") {
+			t.Errorf("Serve() = %v, want main: disk full", err)
+		}
+	case <-time.After(mcctest.Timeout):
+		t.Fatal("Serve did not return")
+	}
+
+	if server.FindPlayer("alice") != nil {
+		t.Error("alice is still connected after shutdown")
+	}
+}
+
+func TestServeLateLogin(t *testing.T) {
+	server := mcc.NewServer(&mcc.Config{
+		Name:         "Test",
+		MaxPlayers:   16,
+		MainLevel:    "main",
+		LoginTimeout: 60,
+	}, mcc.NewMemoryStorage())
+
+	listener := memnet.NewListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(ctx, listener)
+	}()
+
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The connection has finished its handshake, but has not logged in
+	// when the shutdown starts.
+	if _, err := conn.Write([]byte{proto.PacketTypeIdentification, 7, 'a', 'l'}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	packet := make([]byte, 65)
+	conn.SetReadDeadline(time.Now().Add(mcctest.Timeout))
+	if _, err := io.ReadFull(conn, packet); err != nil {
+		t.Fatal(err)
+	}
+
+	var reason [64]byte
+	copy(reason[:], packet[1:])
+	if packet[0] != proto.PacketTypeKick || proto.TrimString(reason) != "Server shutting down!" {
+		t.Errorf("packet = %d %q, want kick Server shutting down!", packet[0], proto.TrimString(reason))
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve() = %v", err)
+		}
+	case <-time.After(mcctest.Timeout):
+		t.Fatal("Serve did not return")
+	}
+
+	if server.FindPlayer("alice") != nil {
+		t.Error("alice is still connected after shutdown")
+	}
+}
+
+func TestServeSilentConnection(t *testing.T) {
+	server := mcc.NewServer(&mcc.Config{
+		Name:         "Test",
+		MaxPlayers:   16,
+		MainLevel:    "main",
+		LoginTimeout: 60,
+	}, mcc.NewMemoryStorage())
+
+	listener := memnet.NewListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(ctx, listener)
+	}()
+
+	// The connection is still waiting for its PROXY or WebSocket handshake
+	// when the shutdown starts.
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve() = %v", err)
+		}
+	case <-time.After(mcctest.Timeout):
+		t.Fatal("Serve did not return")
+	}
+}
+
+func TestServeListenerError(t *testing.T) {
+	server := mcc.NewServer(&mcc.Config{
+		Name:       "Test",
+		MaxPlayers: 16,
+		MainLevel:  "main",
+	}, mcc.NewMemoryStorage())
+
+	listener := failingListener{memnet.NewListener(), make(chan struct{})}
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(context.Background(), listener)
+	}()
+
+	close(listener.accept)
+	select {
+	case err := <-done:
+		if err == nil || err.Error() != "accept failed" {
+			t.Errorf("Serve() = %v, want accept failed", err)
+		}
+	case <-time.After(mcctest.Timeout):
+		t.Fatal("Serve did not return")
+	}
+}
+
+func TestHandshakeLimits(t *testing.T) {
+	harness := mcctest.NewHarness(t, &mcc.Config{
+		Name:                "Test",
+		MaxPlayers:          16,
+		MainLevel:           "main",
+		MaxConnectionsPerIP: 1,
+		LoginTimeout:        1,
+	})
+	defer harness.Close()
+
+	// Pipe connections share one address, so whichever silent connection is
+	// counted first is rejected, and the other one times out.
+	reasons := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		conn, err := harness.Listener.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		go func(conn net.Conn) {
+			packet := make([]byte, 65)
+			conn.SetReadDeadline(time.Now().Add(mcctest.Timeout))
+			if _, err := io.ReadFull(conn, packet); err != nil || packet[0] != proto.PacketTypeKick {
+				reasons <- ""
+				return
+			}
+
+			var reason [64]byte
+			copy(reason[:], packet[1:])
+			reasons <- proto.TrimString(reason)
+		}(conn)
+	}
+
+	got := map[string]bool{<-reasons: true, <-reasons: true}
+	for _, want := range []string{"Too many connections from your IP!", "Login timed out!"} {
+		if !got[want] {
+			t.Errorf("no connection was kicked with %q, got %v", want, got)
+		}
+	}
+}
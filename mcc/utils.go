@@ -31,6 +31,13 @@ type Vector3 struct {
 	X, Y, Z int
 }
 
+// Vector3F represents a three-dimensional floating-point vector.
+type Vector3F struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
 // AABB represents an axis-aligned bounding box.
 type AABB struct {
 	Min, Max Vector3
@@ -0,0 +1,240 @@
+package mcc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketMaxHandshake is the maximum size of the HTTP request of a
+// WebSocket handshake.
+const websocketMaxHandshake = 8192
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+// bufferedConn is a net.Conn whose reads are served by a bufio.Reader, so
+// that bytes peeked while detecting the protocol are not lost.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(p []byte) (int, error) {
+	return conn.reader.Read(p)
+}
+
+// wsConn is a net.Conn that transports a byte stream over WebSocket binary
+// frames, as used by the web client of ClassiCube.
+type wsConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	remaining int64
+	mask      [4]byte
+	maskIndex int
+
+	writeLock sync.Mutex
+}
+
+// isWebsocketRequest reports whether the first bytes sent by a client look
+// like an HTTP request rather than a Classic protocol packet.
+func isWebsocketRequest(reader *bufio.Reader) bool {
+	data, err := reader.Peek(4)
+	return err == nil && string(data) == "GET "
+}
+
+// upgradeWebsocket performs the server side of the WebSocket handshake and
+// returns a connection that reads and writes the payload of binary frames.
+func upgradeWebsocket(conn net.Conn, reader *bufio.Reader) (net.Conn, error) {
+	// The request is read from a separate buffer, so that its size can be
+	// limited. Bytes that were buffered after the request are kept for the
+	// frames.
+	requestReader := bufio.NewReader(io.LimitReader(reader, websocketMaxHandshake))
+	request, err := http.ReadRequest(requestReader)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered, _ := requestReader.Peek(requestReader.Buffered())
+	reader = bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), reader))
+
+	key := request.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(request.Header, "Connection", "upgrade") ||
+		!headerContains(request.Header, "Upgrade", "websocket") ||
+		request.Header.Get("Sec-WebSocket-Version") != "13" ||
+		len(key) == 0 {
+		io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		return nil, errors.New("websocket: invalid handshake")
+	}
+
+	digest := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(digest[:]) + "\r\n"
+	if headerContains(request.Header, "Sec-WebSocket-Protocol", "ClassiCube") {
+		response += "Sec-WebSocket-Protocol: ClassiCube\r\n"
+	}
+	response += "\r\n"
+
+	if _, err := io.WriteString(conn, response); err != nil {
+		return nil, err
+	}
+
+	return &wsConn{Conn: conn, reader: reader}, nil
+}
+
+func headerContains(header http.Header, key, value string) bool {
+	for _, line := range header[http.CanonicalHeaderKey(key)] {
+		for _, token := range strings.Split(line, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (conn *wsConn) readFrameHeader() (opcode byte, length int64, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(conn.reader, header[:]); err != nil {
+		return
+	}
+
+	// Clients must mask all frames that they send, see RFC 6455 §5.1.
+	if header[1]&0x80 == 0 {
+		err = errors.New("websocket: unmasked frame")
+		return
+	}
+
+	opcode = header[0] & 0x0f
+	length = int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext uint16
+		err = binary.Read(conn.reader, binary.BigEndian, &ext)
+		length = int64(ext)
+	case 127:
+		var ext uint64
+		err = binary.Read(conn.reader, binary.BigEndian, &ext)
+		length = int64(ext & (1<<63 - 1))
+	}
+
+	if err != nil {
+		return
+	}
+
+	conn.maskIndex = 0
+	_, err = io.ReadFull(conn.reader, conn.mask[:])
+	return
+}
+
+func (conn *wsConn) readPayload(p []byte) (int, error) {
+	n, err := conn.reader.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= conn.mask[conn.maskIndex&3]
+		conn.maskIndex++
+	}
+
+	conn.remaining -= int64(n)
+	return n, err
+}
+
+// Read implements net.Conn.
+func (conn *wsConn) Read(p []byte) (int, error) {
+	for conn.remaining == 0 {
+		opcode, length, err := conn.readFrameHeader()
+		if err != nil {
+			return 0, err
+		}
+
+		switch opcode {
+		case wsOpContinuation, wsOpText, wsOpBinary:
+			conn.remaining = length
+
+		case wsOpClose, wsOpPing, wsOpPong:
+			if length > 125 {
+				return 0, errors.New("websocket: invalid control frame")
+			}
+
+			payload := make([]byte, length)
+			conn.remaining = length
+			if _, err := io.ReadFull(readerFunc(conn.readPayload), payload); err != nil {
+				return 0, err
+			}
+
+			switch opcode {
+			case wsOpClose:
+				conn.writeFrame(wsOpClose, payload)
+				return 0, io.EOF
+			case wsOpPing:
+				conn.writeFrame(wsOpPong, payload)
+			}
+
+		default:
+			return 0, errors.New("websocket: invalid opcode")
+		}
+	}
+
+	if int64(len(p)) > conn.remaining {
+		p = p[:conn.remaining]
+	}
+
+	return conn.readPayload(p)
+}
+
+// Write implements net.Conn.
+func (conn *wsConn) Write(p []byte) (int, error) {
+	if err := conn.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (conn *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 2, len(payload)+10)
+	frame[0] = 0x80 | opcode
+	switch {
+	case len(payload) < 126:
+		frame[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		frame[1] = 126
+		frame = append(frame, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame[1] = 127
+		frame = append(frame, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+	_, err := conn.Conn.Write(frame)
+	return err
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (fn readerFunc) Read(p []byte) (int, error) {
+	return fn(p)
+}
@@ -0,0 +1,201 @@
+package mcc_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndreasGoulas/go-mcc/mcc/internal/proto"
+	"github.com/AndreasGoulas/go-mcc/mcc/mcctest"
+)
+
+const (
+	wsOpBinary = 0x2
+	wsOpClose  = 0x8
+	wsOpPing   = 0x9
+	wsOpPong   = 0xa
+)
+
+// wsHandshake performs the client side of the WebSocket handshake with the
+// sample key of RFC 6455.
+func wsHandshake(t *testing.T, conn net.Conn) *bufio.Reader {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(mcctest.Timeout))
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Protocol: ClassiCube\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("StatusCode = %d, want 101", response.StatusCode)
+	}
+	if accept := response.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q, want s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", accept)
+	}
+	if protocol := response.Header.Get("Sec-WebSocket-Protocol"); protocol != "ClassiCube" {
+		t.Errorf("Sec-WebSocket-Protocol = %q, want ClassiCube", protocol)
+	}
+
+	return reader
+}
+
+// wsWriteFrame writes a final frame of up to 64 KiB that is masked if
+// masked is true.
+func wsWriteFrame(t *testing.T, conn net.Conn, opcode byte, payload []byte, masked bool) {
+	t.Helper()
+	frame := []byte{0x80 | opcode, byte(len(payload))}
+	if len(payload) >= 126 {
+		frame[1] = 126
+		frame = append(frame, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	}
+
+	if masked {
+		mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+		frame[1] |= 0x80
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i&3])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wsReadFrame reads an unmasked frame sent by the server.
+func wsReadFrame(t *testing.T, reader *bufio.Reader) (opcode byte, payload []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	if header[1]&0x80 != 0 {
+		t.Fatal("server sent a masked frame")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext uint16
+		binary.Read(reader, binary.BigEndian, &ext)
+		length = uint64(ext)
+	case 127:
+		binary.Read(reader, binary.BigEndian, &length)
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	return header[0] & 0x0f, payload
+}
+
+// wsIdentification returns the identification packet of a client without
+// CPE support.
+func wsIdentification(name string) []byte {
+	paddedName, key := proto.PadString(name), proto.PadString("")
+	packet := append([]byte{proto.PacketTypeIdentification, 7}, paddedName[:]...)
+	packet = append(packet, key[:]...)
+	return append(packet, 0)
+}
+
+func TestWebsocket(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	conn, err := harness.Listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	reader := wsHandshake(t, conn)
+
+	wsWriteFrame(t, conn, wsOpPing, []byte("ping"), true)
+	if opcode, payload := wsReadFrame(t, reader); opcode != wsOpPong || string(payload) != "ping" {
+		t.Errorf("frame = %d %q, want pong ping", opcode, payload)
+	}
+
+	wsWriteFrame(t, conn, wsOpBinary, wsIdentification("alice"), true)
+
+	// The identification of the server may be batched with other packets,
+	// but it is the only one that contains the MOTD.
+	motd := proto.PadString("Test server")
+	for {
+		opcode, payload := wsReadFrame(t, reader)
+		if opcode != wsOpBinary {
+			t.Fatalf("opcode = %d, want binary", opcode)
+		}
+		if bytes.Contains(payload, motd[:]) {
+			break
+		}
+	}
+
+	wsWriteFrame(t, conn, wsOpClose, []byte{0x03, 0xe8}, true)
+	var payload []byte
+	for opcode := byte(0); opcode != wsOpClose; {
+		opcode, payload = wsReadFrame(t, reader)
+	}
+	if string(payload) != "\x03\xe8" {
+		t.Errorf("close payload = %v, want 1000", payload)
+	}
+}
+
+func TestWebsocketUnmasked(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	conn, err := harness.Listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	reader := wsHandshake(t, conn)
+	wsWriteFrame(t, conn, wsOpBinary, wsIdentification("alice"), false)
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("ReadByte() = %v after an unmasked frame, want EOF", err)
+	}
+}
+
+func TestWebsocketHandshakeLimit(t *testing.T) {
+	harness := mcctest.NewHarness(t, nil)
+	defer harness.Close()
+
+	conn, err := harness.Listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(mcctest.Timeout))
+	header := "X-Padding: " + strings.Repeat("a", 1000) + "\r\n"
+	request := "GET / HTTP/1.1\r\nHost: localhost\r\n" + strings.Repeat(header, 1000)
+	if _, err := io.WriteString(conn, request); err == nil {
+		t.Error("server read a 1 MB handshake")
+	}
+}